@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var yamlSerializer = json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme, json.SerializerOptions{Yaml: true})
+
+// backupObject writes the YAML of an object about to be changed to -backup-dir so it can be
+// restored by hand, nothing is written when the flag is unset
+func (c *kubeClient) backupObject(obj runtime.Object) error {
+	_, err := c.backupFile(obj)
+	return err
+}
+
+// backupFile is backupObject returning the path of the written backup, empty when nothing was
+// written
+func (c *kubeClient) backupFile(obj runtime.Object) (string, error) {
+	if c.opts.BackupDir == "" {
+		return "", nil
+	}
+
+	// typed objects returned by the clientset don't carry their kind, look it up in the scheme
+	obj = obj.DeepCopyObject()
+	kinds, _, err := scheme.Scheme.ObjectKinds(obj)
+	if err != nil {
+		return "", fmt.Errorf("backing up object: %w", err)
+	}
+	obj.GetObjectKind().SetGroupVersionKind(kinds[0])
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", fmt.Errorf("backing up object: %w", err)
+	}
+
+	if err := os.MkdirAll(c.opts.BackupDir, 0o755); err != nil {
+		return "", fmt.Errorf("backing up %s %s/%s: %w", kinds[0].Kind, accessor.GetNamespace(), accessor.GetName(), err)
+	}
+	name := fmt.Sprintf("%s_%s_%s_%s.yaml", accessor.GetNamespace(), kinds[0].Kind, accessor.GetName(), time.Now().UTC().Format("20060102T150405Z"))
+	path := filepath.Join(c.opts.BackupDir, name)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("backing up %s %s/%s: %w", kinds[0].Kind, accessor.GetNamespace(), accessor.GetName(), err)
+	}
+	defer file.Close()
+	if err := yamlSerializer.Encode(obj, file); err != nil {
+		return "", fmt.Errorf("backing up %s %s/%s: %w", kinds[0].Kind, accessor.GetNamespace(), accessor.GetName(), err)
+	}
+	return path, file.Close()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// clusterResult is the outcome of running against one kubeconfig context
+type clusterResult struct {
+	Cluster   string `json:"cluster"`
+	Server    string `json:"server,omitempty"`
+	Restarted int    `json:"restarted"`
+	Skipped   int    `json:"skipped"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runClusters runs against every kubeconfig context, sequentially unless parallel is set, and
+// reports an overview of the results per cluster
+func runClusters(ctx context.Context, opts Options, conn connectionOptions, report *reportStream, contexts []string, parallel bool) error {
+	results := make([]clusterResult, len(contexts))
+
+	// confirm every cluster up front so parallel runs don't prompt at the same time
+	configs := make([]*rest.Config, len(contexts))
+	errs := make([]error, len(contexts))
+	in := bufio.NewReader(os.Stdin)
+	log := newLogger(opts.Verbose)
+	for i, name := range contexts {
+		configs[i], errs[i] = conn.restConfig(name)
+		if errs[i] != nil {
+			continue
+		}
+		log.Info(fmt.Sprintf("using context: %s server: %s", name, configs[i].Host))
+		if opts.ConfirmCluster {
+			if err := confirmCluster(in, name, configs[i].Host); err != nil {
+				return err
+			}
+		}
+	}
+
+	runCluster := func(i int) {
+		results[i] = clusterResult{Cluster: contexts[i]}
+		k := &kubeClient{opts: opts, cluster: contexts[i], report: report, log: log}
+		// every cluster writes a report of its own, sharing one file would keep only the last
+		if report == nil && opts.ReportFile != "" && opts.ReportFile != "-" {
+			k.opts.ReportFile = clusterReportFile(opts.ReportFile, contexts[i])
+		}
+
+		err := errs[i]
+		if err == nil {
+			k.server = configs[i].Host
+			k.config = configs[i]
+			results[i].Server = k.server
+			k.clientSet, err = kubernetes.NewForConfig(configs[i])
+		}
+		var result Result
+		if err == nil {
+			result, err = k.run(ctx)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		if !opts.preview() {
+			k.printSummary(result)
+		}
+		results[i].Restarted = len(result.Restarted)
+		results[i].Skipped = len(result.Skipped)
+		results[i].Failed = len(result.Errors)
+	}
+
+	if parallel {
+		var wg sync.WaitGroup
+		for i := range contexts {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				runCluster(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range contexts {
+			if ctx.Err() != nil {
+				results[i] = clusterResult{Cluster: contexts[i], Error: "not attempted"}
+				continue
+			}
+			runCluster(i)
+		}
+	}
+
+	printClusterResults(opts.Output, results)
+
+	for _, result := range results {
+		if result.Error != "" || result.Failed > 0 {
+			return fmt.Errorf("restarts failed in one or more clusters")
+		}
+	}
+	return nil
+}
+
+// clusterReportFile adds the context name to a report file name, before its extensions, so
+// reports.json becomes reports-prod.json and a .json.gz report keeps both extensions
+func clusterReportFile(path, cluster string) string {
+	name := unsafeFileChars.ReplaceAllString(cluster, "_")
+	dir, file := filepath.Split(path)
+	base, ext, _ := strings.Cut(file, ".")
+	if ext != "" {
+		ext = "." + ext
+	}
+	return filepath.Join(dir, base+"-"+name+ext)
+}
+
+// unsafeFileChars matches what a context name, e.g. an EKS ARN, can't carry into a file name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func printClusterResults(output string, results []clusterResult) {
+	if output == "json" {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tRESTARTED\tSKIPPED\tFAILED\tERROR")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", result.Cluster, result.Restarted, result.Skipped, result.Failed, result.Error)
+	}
+	w.Flush()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultKubeconfigSecretKey is the Secret data key holding the kubeconfig for -kubeconfig-secret
+const DefaultKubeconfigSecretKey = "kubeconfig"
+
+// connectionOptions holds the flags controlling how the tool connects to a cluster
+type connectionOptions struct {
+	kubeconfigs       []string
+	impersonate       string
+	impersonateGroups []string
+	insecure          bool
+	apiTimeout        time.Duration
+	// server and the certificate files connect directly instead of through a kubeconfig
+	server               string
+	clientCertificate    string
+	clientKey            string
+	certificateAuthority string
+	// kubeconfigSecret is the namespace/name of a Secret in the cluster the kubeconfig files, or
+	// the in-cluster config, connect to, holding the kubeconfig of the cluster to restart in
+	kubeconfigSecret    string
+	kubeconfigSecretKey string
+	// kubeconfigData holds the kubeconfig read from kubeconfigSecret by loadKubeconfigSecret
+	kubeconfigData []byte
+}
+
+// validate rejects incomplete direct connection flags
+func (o connectionOptions) validate() error {
+	if (o.clientCertificate == "") != (o.clientKey == "") {
+		return fmt.Errorf("-client-certificate and -client-key must be set together")
+	}
+	if o.server == "" && (o.clientCertificate != "" || o.certificateAuthority != "") {
+		return fmt.Errorf("-client-certificate, -client-key and -certificate-authority require -server")
+	}
+	if o.kubeconfigSecret != "" {
+		if namespace, name, ok := strings.Cut(o.kubeconfigSecret, "/"); !ok || namespace == "" || name == "" {
+			return fmt.Errorf("-kubeconfig-secret must be namespace/name: %s", o.kubeconfigSecret)
+		}
+		if o.server != "" {
+			return fmt.Errorf("-kubeconfig-secret can't be combined with -server")
+		}
+	}
+	return nil
+}
+
+// loadClientConfig resolves kubeconfig files the same way kubectl does. Explicit -kubeconfig paths
+// take precedence over the KUBECONFIG environment variable, which in turn takes precedence over
+// ~/.kube/config, and multiple files are merged.
+func loadClientConfig(kubeconfigs []string, context string) clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if len(kubeconfigs) > 0 {
+		rules.Precedence = kubeconfigs
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+// resolveContext returns the name of the context that is used for an empty or explicit context
+func (o connectionOptions) resolveContext(context string) (string, error) {
+	if context != "" || o.server != "" {
+		return context, nil
+	}
+	clientConfig, err := o.clientConfig(context)
+	if err != nil {
+		return "", err
+	}
+	raw, err := clientConfig.RawConfig()
+	if err != nil {
+		return "", err
+	}
+	return raw.CurrentContext, nil
+}
+
+// confirmCluster asks the operator to type the context name before anything is changed in it
+func confirmCluster(in *bufio.Reader, context, server string) error {
+	fmt.Printf("about to run against context: %s (%s), type the context name to continue: ", context, server)
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("reading confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != context {
+		return fmt.Errorf("confirmation %q doesn't match context %s", strings.TrimSpace(answer), context)
+	}
+	return nil
+}
+
+// restConfig builds the client configuration for a kubeconfig context, an empty context selects
+// the current one
+func (o connectionOptions) restConfig(context string) (*rest.Config, error) {
+	config, err := o.baseConfig(context)
+	if err != nil {
+		return nil, err
+	}
+	o.applyOverrides(config)
+	return config, nil
+}
+
+// applyOverrides mirrors kubectl's identity and TLS overrides on top of a loaded configuration and
+// bounds each API call by -api-timeout
+func (o connectionOptions) applyOverrides(config *rest.Config) {
+	config.Impersonate.UserName = o.impersonate
+	config.Impersonate.Groups = o.impersonateGroups
+	if o.insecure {
+		config.TLSClientConfig.Insecure = true
+		config.TLSClientConfig.CAFile = ""
+		config.TLSClientConfig.CAData = nil
+	}
+	if o.apiTimeout > 0 {
+		config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return &apiTimeoutTransport{next: rt, timeout: o.apiTimeout}
+		})
+	}
+}
+
+// baseConfig builds the client configuration from -server and the certificate flags when set,
+// for environments without a kubeconfig or exec plugins, and from the kubeconfig otherwise
+func (o connectionOptions) baseConfig(context string) (*rest.Config, error) {
+	if o.server == "" {
+		clientConfig, err := o.clientConfig(context)
+		if err != nil {
+			return nil, err
+		}
+		return clientConfig.ClientConfig()
+	}
+	return &rest.Config{
+		Host: o.server,
+		TLSClientConfig: rest.TLSClientConfig{
+			CertFile: o.clientCertificate,
+			KeyFile:  o.clientKey,
+			CAFile:   o.certificateAuthority,
+		},
+	}, nil
+}
+
+// clientConfig loads the kubeconfig from -kubeconfig-secret when set and from the kubeconfig files
+// otherwise
+func (o connectionOptions) clientConfig(contextName string) (clientcmd.ClientConfig, error) {
+	if o.kubeconfigSecret == "" {
+		return loadClientConfig(o.kubeconfigs, contextName), nil
+	}
+	if o.kubeconfigData == nil {
+		return nil, fmt.Errorf("kubeconfig secret %s wasn't loaded", o.kubeconfigSecret)
+	}
+	config, err := clientcmd.Load(o.kubeconfigData)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the kubeconfig in secret %s: %w", o.kubeconfigSecret, err)
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*config, contextName, &clientcmd.ConfigOverrides{}, nil), nil
+}
+
+// loadKubeconfigSecret reads the kubeconfig from -kubeconfig-secret once, connecting to the
+// Secret's cluster through the kubeconfig files or, running in a pod without any, the in-cluster
+// config, with -as, -insecure-skip-tls-verify and -api-timeout applied. This lets a tool running in
+// a hub cluster restart in spoke clusters without mounting their secrets.
+func (o *connectionOptions) loadKubeconfigSecret(ctx context.Context) error {
+	if o.kubeconfigSecret == "" {
+		return nil
+	}
+	hub, err := loadClientConfig(o.kubeconfigs, "").ClientConfig()
+	if err != nil {
+		return err
+	}
+	o.applyOverrides(hub)
+	clientSet, err := kubernetes.NewForConfig(hub)
+	if err != nil {
+		return err
+	}
+
+	namespace, name, _ := strings.Cut(o.kubeconfigSecret, "/")
+	secret, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("reading kubeconfig secret %s: %w", o.kubeconfigSecret, err)
+	}
+	data, ok := secret.Data[o.kubeconfigSecretKey]
+	if !ok {
+		return fmt.Errorf("kubeconfig secret %s has no key %s", o.kubeconfigSecret, o.kubeconfigSecretKey)
+	}
+	o.kubeconfigData = data
+	return nil
+}
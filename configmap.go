@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"strings"
+)
+
+const ConfigHashAnnotation = "figure.restart/config-hash"
+
+// errConfigUnchanged is returned by the restart methods when a workload was already restarted
+// for the current ConfigMap contents
+var errConfigUnchanged = errors.New("configmap unchanged since last restart")
+
+// parseObjectRef splits a namespace/name reference
+func parseObjectRef(ref string) (string, string, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("expected namespace/name: %q", ref)
+	}
+	return namespace, name, nil
+}
+
+// loadConfigHash computes the hash of the watched ConfigMap for the current pass
+func (c *kubeClient) loadConfigHash(ctx context.Context) error {
+	cm, err := c.clientSet.CoreV1().ConfigMaps(c.opts.ConfigMapNamespace).Get(ctx, c.opts.ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting configmap %s/%s: %w", c.opts.ConfigMapNamespace, c.opts.ConfigMapName, err)
+	}
+	c.configHash = configMapHash(cm)
+	return nil
+}
+
+// configMapHash returns a stable hash of the ConfigMap data
+func configMapHash(cm *v1.ConfigMap) string {
+	keys := make([]string, 0, len(cm.Data)+len(cm.BinaryData))
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	for key := range cm.BinaryData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		if value, ok := cm.Data[key]; ok {
+			hash.Write([]byte(value))
+		} else {
+			hash.Write(cm.BinaryData[key])
+		}
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// configUnchanged reports whether the object was already restarted for the current ConfigMap hash
+func (c *kubeClient) configUnchanged(meta metav1.ObjectMeta) bool {
+	return c.configHash != "" && meta.Annotations[ConfigHashAnnotation] == c.configHash
+}
+
+// stampConfigHash records the current ConfigMap hash on the object
+func (c *kubeClient) stampConfigHash(meta *metav1.ObjectMeta) {
+	if c.configHash == "" {
+		return
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[ConfigHashAnnotation] = c.configHash
+}
+
+// podUsesConfigMap reports whether the pod mounts or otherwise consumes the ConfigMap
+func podUsesConfigMap(pod v1.Pod, namespace, name string) bool {
+	if pod.Namespace != namespace {
+		return false
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == name {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil && source.ConfigMap.Name == name {
+					return true
+				}
+			}
+		}
+	}
+
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == name {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
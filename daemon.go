@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"time"
+)
+
+// runDaemon restarts matching pods every interval until the context is cancelled. Pods are
+// selected from an informer backed cache so each pass doesn't have to list every pod in the
+// cluster from the API server. Workloads found with -by-workload are listed directly each pass.
+func (c *kubeClient) runDaemon(ctx context.Context) (Result, error) {
+	var pass func() (Result, error)
+	if c.opts.ByWorkload {
+		pass = func() (Result, error) {
+			return c.restartWorkloads(ctx)
+		}
+	} else {
+		factory := informers.NewSharedInformerFactoryWithOptions(c.clientSet, 0,
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = c.labelSelector()
+				options.FieldSelector = c.opts.FieldSelector
+			}))
+		podLister := factory.Core().V1().Pods().Lister()
+
+		factory.Start(ctx.Done())
+		defer factory.Shutdown()
+
+		for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return Result{}, fmt.Errorf("timed out waiting for %v cache to sync", informerType)
+			}
+		}
+
+		pass = func() (Result, error) {
+			cached, err := podLister.List(labels.Everything())
+			if err != nil {
+				return Result{}, err
+			}
+
+			// the lister hands out shared cache objects, copy them before use
+			pods := make([]v1.Pod, 0, len(cached))
+			for _, pod := range cached {
+				pods = append(pods, *pod.DeepCopy())
+			}
+			if c.scopedToNamespaces() {
+				namespaces, err := c.targetNamespaces(ctx)
+				if err != nil {
+					return Result{}, err
+				}
+				pods = inNamespaces(pods, namespaces)
+			}
+			return c.restartPods(ctx, pods), nil
+		}
+	}
+
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := pass()
+		if err != nil {
+			return result, err
+		}
+		if !c.opts.preview() {
+			c.printSummary(result)
+		}
+
+		select {
+		case <-ctx.Done():
+			return result, nil
+		case <-ticker.C:
+		}
+	}
+}
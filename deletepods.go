@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// evictManagedPods restarts a controller by evicting its pods one at a time instead of changing
+// its template, for workloads whose template is reverted by GitOps reconciliation. Evictions honor
+// PodDisruptionBudgets and the controller is back at full availability before the next pod goes.
+func (c *kubeClient) evictManagedPods(ctx context.Context, resourceType, name, namespace string) ([]string, error) {
+	var meta metav1.ObjectMeta
+	var selector *metav1.LabelSelector
+	var spec v1.PodSpec
+	var desired int32
+	switch resourceType {
+	case "ReplicaSet":
+		rs, err := c.clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		if owner := metav1.GetControllerOf(rs); owner != nil && getOwnerResourceType(*owner) == "Deployment" {
+			return c.evictManagedPods(ctx, "Deployment", owner.Name, namespace)
+		}
+		if err := c.replicaGuard(resourceType, rs.ObjectMeta, rs.Spec.Replicas); err != nil {
+			return nil, err
+		}
+		meta, selector, spec, desired = rs.ObjectMeta, rs.Spec.Selector, rs.Spec.Template.Spec, replicaCount(rs.Spec.Replicas)
+	case "Deployment":
+		deploy, err := c.clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		if err := c.replicaGuard(resourceType, deploy.ObjectMeta, deploy.Spec.Replicas); err != nil {
+			return nil, err
+		}
+		if err := c.healthGuard(deploy.Status.ReadyReplicas, replicaCount(deploy.Spec.Replicas)); err != nil {
+			return nil, err
+		}
+		if err := c.hpaGuard(ctx, resourceType, deploy.ObjectMeta); err != nil {
+			return nil, err
+		}
+		meta, selector, spec, desired = deploy.ObjectMeta, deploy.Spec.Selector, deploy.Spec.Template.Spec, replicaCount(deploy.Spec.Replicas)
+	case "StatefulSet":
+		sts, err := c.clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		if err := c.replicaGuard(resourceType, sts.ObjectMeta, sts.Spec.Replicas); err != nil {
+			return nil, err
+		}
+		if err := c.healthGuard(sts.Status.ReadyReplicas, replicaCount(sts.Spec.Replicas)); err != nil {
+			return nil, err
+		}
+		if err := c.hpaGuard(ctx, resourceType, sts.ObjectMeta); err != nil {
+			return nil, err
+		}
+		meta, selector, spec, desired = sts.ObjectMeta, sts.Spec.Selector, sts.Spec.Template.Spec, replicaCount(sts.Spec.Replicas)
+	case "DaemonSet":
+		ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		if err := c.healthGuard(ds.Status.NumberReady, ds.Status.DesiredNumberScheduled); err != nil {
+			return nil, err
+		}
+		meta, selector, spec, desired = ds.ObjectMeta, ds.Spec.Selector, ds.Spec.Template.Spec, ds.Status.DesiredNumberScheduled
+	default:
+		return nil, nil
+	}
+	if err := c.restartGuard(meta); err != nil {
+		return nil, err
+	}
+
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	listOptions := metav1.ListOptions{LabelSelector: labelSelector.String()}
+	owners, err := c.podControllers(ctx, resourceType, meta, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.clientSet.CoreV1().Pods(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods.Items {
+		// pods already on their way out are replaced without our help, and pods matching the
+		// selector but controlled by another controller aren't ours to evict
+		if pod.DeletionTimestamp != nil || !controlledBy(pod, owners) {
+			continue
+		}
+		c.infof("evicting pod: %s of %s: %s in namespace: %s", pod.Name, resourceType, name, namespace)
+		if err := c.evictPod(ctx, pod.Name, pod.Namespace); err != nil {
+			return nil, fmt.Errorf("evicting pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		if c.opts.DeleteTimeout > 0 {
+			if err := c.waitForPodDeletion(ctx, pod); err != nil {
+				return nil, err
+			}
+		}
+		// the controller status can still count the evicted pod, wait for its replacement to be
+		// ready before trusting the rollout status, so evictions never overlap
+		if err := c.waitForReplacement(ctx, pod, owners, listOptions, desired); err != nil {
+			return nil, err
+		}
+		if err := c.waitForRollout(ctx, resourceType, name, namespace); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.stampController(ctx, resourceType, name, namespace); err != nil {
+		return nil, err
+	}
+	return containerImages(spec), nil
+}
+
+// podControllers returns the UIDs of the objects directly controlling the pods of the controller,
+// the controller itself or, for a Deployment, its ReplicaSets
+func (c *kubeClient) podControllers(ctx context.Context, resourceType string, meta metav1.ObjectMeta, listOptions metav1.ListOptions) (map[types.UID]bool, error) {
+	owners := map[types.UID]bool{meta.UID: true}
+	if resourceType != "Deployment" {
+		return owners, nil
+	}
+	replicaSets, err := c.clientSet.AppsV1().ReplicaSets(meta.Namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, rs := range replicaSets.Items {
+		if owner := metav1.GetControllerOf(&rs); owner != nil && owner.UID == meta.UID {
+			owners[rs.UID] = true
+		}
+	}
+	return owners, nil
+}
+
+// controlledBy reports whether the pod's controller is one of the owners
+func controlledBy(pod v1.Pod, owners map[types.UID]bool) bool {
+	owner := metav1.GetControllerOf(&pod)
+	return owner != nil && owners[owner.UID]
+}
+
+// waitForReplacement waits until the controller has as many ready pods as it desires again, none
+// of them the evicted pod. Evicting doesn't change the template, so the replacement is controlled
+// by one of the owners found before the first eviction.
+func (c *kubeClient) waitForReplacement(ctx context.Context, evicted v1.Pod, owners map[types.UID]bool, listOptions metav1.ListOptions, desired int32) error {
+	err := wait.PollUntilContextTimeout(ctx, ConfigRestartInterval*time.Second, c.opts.WaitTimeout, true, func(ctx context.Context) (bool, error) {
+		pods, err := c.clientSet.CoreV1().Pods(evicted.Namespace).List(ctx, listOptions)
+		if err != nil {
+			return false, err
+		}
+		ready := int32(0)
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.UID == evicted.UID || pod.DeletionTimestamp != nil || !controlledBy(*pod, owners) {
+				continue
+			}
+			if isPodReady(pod) {
+				ready++
+			}
+		}
+		return ready >= desired, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for a ready replacement of evicted pod %s/%s: %w", evicted.Namespace, evicted.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sort"
+	"strings"
+)
+
+// diffWorkItems previews the restart of every work item with a server-side apply dry run and
+// prints how the object returned by the server differs from the current one, including any
+// defaulting or mutating webhooks. Nothing is changed.
+func (c *kubeClient) diffWorkItems(ctx context.Context, items []workItem) {
+	for _, item := range items {
+		fmt.Printf("--- %s/%s in namespace: %s\n", item.kind, item.name, item.namespace)
+		if err := c.diffWorkItem(ctx, item); err != nil {
+			c.warnf("failed previewing %s: %s", item.key(), err)
+		}
+	}
+}
+
+func (c *kubeClient) diffWorkItem(ctx context.Context, item workItem) error {
+	kind, name := item.resourceType, item.name
+	// ReplicaSets are restarted through their Deployment
+	if kind == "ReplicaSet" {
+		rs, err := c.clientSet.AppsV1().ReplicaSets(item.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		owner := metav1.GetControllerOf(rs)
+		if owner == nil || getOwnerResourceType(*owner) != "Deployment" {
+			fmt.Println("no owning deployment, nothing would change")
+			return nil
+		}
+		kind, name = "Deployment", owner.Name
+	}
+
+	template := corev1ac.PodTemplateSpec().WithAnnotations(map[string]string{"kubectl.kubernetes.io/restartedAt": c.restartTimestamp()})
+	opts := metav1.ApplyOptions{FieldManager: c.opts.FieldManager, DryRun: []string{metav1.DryRunAll}, Force: true}
+
+	var current, applied interface{}
+	var err error
+	switch kind {
+	case "Deployment":
+		current, err = c.clientSet.AppsV1().Deployments(item.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			applied, err = c.clientSet.AppsV1().Deployments(item.namespace).Apply(ctx, appsv1ac.Deployment(name, item.namespace).WithSpec(appsv1ac.DeploymentSpec().WithTemplate(template)), opts)
+		}
+	case "StatefulSet":
+		current, err = c.clientSet.AppsV1().StatefulSets(item.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			applied, err = c.clientSet.AppsV1().StatefulSets(item.namespace).Apply(ctx, appsv1ac.StatefulSet(name, item.namespace).WithSpec(appsv1ac.StatefulSetSpec().WithTemplate(template)), opts)
+		}
+	case "DaemonSet":
+		current, err = c.clientSet.AppsV1().DaemonSets(item.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			applied, err = c.clientSet.AppsV1().DaemonSets(item.namespace).Apply(ctx, appsv1ac.DaemonSet(name, item.namespace).WithSpec(appsv1ac.DaemonSetSpec().WithTemplate(template)), opts)
+		}
+	case "Pod":
+		fmt.Println("standalone pod would be recreated under a new name and then removed")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	changes, err := diffObjects(current, applied)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return nil
+}
+
+// diffObjects lists the fields that differ between two objects as +, - and ~ lines, ignoring
+// managedFields which always change
+func diffObjects(before, after interface{}) ([]string, error) {
+	beforeFields, err := flattenObject(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := flattenObject(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for path, value := range afterFields {
+		old, ok := beforeFields[path]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ %s: %s", path, value))
+		case old != value:
+			changes = append(changes, fmt.Sprintf("~ %s: %s -> %s", path, old, value))
+		}
+	}
+	for path, value := range beforeFields {
+		if _, ok := afterFields[path]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s: %s", path, value))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i][2:] < changes[j][2:]
+	})
+	return changes, nil
+}
+
+// flattenObject maps every leaf field of the object's JSON form to its value by dotted path
+func flattenObject(obj interface{}) (map[string]string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	var walk func(path string, node interface{})
+	walk = func(path string, node interface{}) {
+		switch value := node.(type) {
+		case map[string]interface{}:
+			for key, child := range value {
+				if path == "metadata" && key == "managedFields" {
+					continue
+				}
+				walk(strings.TrimPrefix(path+"."+key, "."), child)
+			}
+		case []interface{}:
+			for i, child := range value {
+				walk(fmt.Sprintf("%s[%d]", path, i), child)
+			}
+		default:
+			out, _ := json.Marshal(value)
+			fields[path] = string(out)
+		}
+	}
+	walk("", tree)
+	return fields, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sync"
+)
+
+// scopedToNamespaces reports whether discovery is limited to a set of namespaces
+func (c *kubeClient) scopedToNamespaces() bool {
+	return len(c.opts.Namespaces) > 0 || c.opts.NamespaceSelector != ""
+}
+
+// targetNamespaces resolves the namespace allowlist and selector into the namespaces to scan.
+// When both are set only allowlisted namespaces matching the selector are returned.
+func (c *kubeClient) targetNamespaces(ctx context.Context) ([]string, error) {
+	if c.opts.NamespaceSelector == "" {
+		return c.opts.Namespaces, nil
+	}
+
+	list, err := c.clientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: c.opts.NamespaceSelector})
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+
+	allowed := make(map[string]bool, len(c.opts.Namespaces))
+	for _, ns := range c.opts.Namespaces {
+		allowed[ns] = true
+	}
+
+	var namespaces []string
+	for _, ns := range list.Items {
+		if len(allowed) == 0 || allowed[ns.Name] {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	return namespaces, nil
+}
+
+// listPods retrieves the candidate pods, either cluster wide or from each target namespace
+func (c *kubeClient) listPods(ctx context.Context) ([]v1.Pod, error) {
+	if !c.scopedToNamespaces() {
+		// It seems I cannot filter the lookup and must retrieve all PODs in the cluster as there are no
+		// known labels to select, and `database` can be anywhere in the name
+		// https://github.com/kubernetes/kubernetes/issues/72196
+		// https://github.com/kubernetes/kubernetes/issues/109400
+		pods, err := c.clientSet.CoreV1().Pods("").List(ctx, c.podListOptions())
+		if err != nil {
+			return nil, err
+		}
+		return pods.Items, nil
+	}
+
+	namespaces, err := c.targetNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		pods    []v1.Pod
+		listErr error
+	)
+	// bound the number of namespaces being listed at once
+	sem := make(chan struct{}, c.opts.NamespaceParallel)
+	for _, ns := range namespaces {
+		wg.Add(1)
+		go func(ns string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			list, err := c.clientSet.CoreV1().Pods(ns).List(ctx, c.podListOptions())
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if listErr == nil {
+					listErr = fmt.Errorf("listing pods in namespace %s: %w", ns, err)
+				}
+				return
+			}
+			pods = append(pods, list.Items...)
+		}(ns)
+	}
+	wg.Wait()
+
+	return pods, listErr
+}
+
+// inNamespaces filters pods down to the given namespaces
+func inNamespaces(pods []v1.Pod, namespaces []string) []v1.Pod {
+	allowed := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		allowed[ns] = true
+	}
+
+	var filtered []v1.Pod
+	for _, pod := range pods {
+		if allowed[pod.Namespace] {
+			filtered = append(filtered, pod)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// restartStages restarts the work items. With -batch-size they are restarted in batches, pausing
+// for -batch-delay between them, and every batch is restarted like a whole run would be.
+func (c *kubeClient) restartStages(ctx context.Context, sum *summary, items []workItem) {
+	if c.opts.BatchSize == 0 || len(items) <= c.opts.BatchSize {
+		c.restartNamespaces(ctx, sum, items)
+		if c.opts.WaitShared {
+			c.waitForAll(ctx, sum, sum.restartedSince(0))
+		}
+		return
+	}
+
+	batches := (len(items) + c.opts.BatchSize - 1) / c.opts.BatchSize
+	for i := 0; i < batches; i++ {
+		batch := items[i*c.opts.BatchSize : min((i+1)*c.opts.BatchSize, len(items))]
+		if i > 0 && c.opts.BatchDelay > 0 && ctx.Err() == nil && !c.stopped(sum) {
+			c.infof("waiting %s before batch %d/%d", c.opts.BatchDelay, i+1, batches)
+			timer := time.NewTimer(c.opts.BatchDelay)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+
+		c.infof("starting batch %d/%d with %d resources", i+1, batches, len(batch))
+		restarted, failed := sum.counts()
+		// once interrupted every remaining batch is reported as not attempted
+		c.restartNamespaces(ctx, sum, batch)
+		if c.opts.WaitShared {
+			c.waitForAll(ctx, sum, sum.restartedSince(restarted))
+		}
+		restartedNow, failedNow := sum.counts()
+		c.infof("finished batch %d/%d: %d restarted, %d failed", i+1, batches, restartedNow-restarted, failedNow-failed)
+	}
+}
+
+// restartNamespaces restarts the work items. With -namespace-delay namespaces are restarted one
+// after another in name order, pausing between them, -concurrency and -parallel-kinds then apply
+// within each namespace.
+func (c *kubeClient) restartNamespaces(ctx context.Context, sum *summary, items []workItem) {
+	if c.opts.NamespaceDelay == 0 {
+		c.restartKinds(ctx, sum, items)
+		return
+	}
+
+	byNamespace := make(map[string][]workItem)
+	var namespaces []string
+	for _, item := range items {
+		if _, ok := byNamespace[item.namespace]; !ok {
+			namespaces = append(namespaces, item.namespace)
+		}
+		byNamespace[item.namespace] = append(byNamespace[item.namespace], item)
+	}
+	sort.Strings(namespaces)
+
+	for i, ns := range namespaces {
+		if i > 0 && !c.stopped(sum) {
+			c.infof("waiting %s before restarting namespace: %s", c.opts.NamespaceDelay, ns)
+			timer := time.NewTimer(c.opts.NamespaceDelay)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		// once interrupted every remaining namespace is reported as not attempted
+		c.restartKinds(ctx, sum, byNamespace[ns])
+	}
+}
+
+// restartKinds restarts the work items, with -parallel-kinds one kind at a time so every resource
+// of a kind has finished, and with -wait rolled out, before the next kind starts
+func (c *kubeClient) restartKinds(ctx context.Context, sum *summary, items []workItem) {
+	if len(c.opts.KindOrder) == 0 {
+		c.restartWorkItems(ctx, sum, items)
+		return
+	}
+
+	stages := make(map[string][]workItem)
+	var unlisted []string
+	for _, item := range items {
+		// ReplicaSets are restarted through their Deployment
+		kind := item.kind
+		if kind == "ReplicaSet" {
+			kind = "Deployment"
+		}
+		if _, ok := stages[kind]; !ok && !slices.Contains(c.opts.KindOrder, kind) {
+			unlisted = append(unlisted, kind)
+		}
+		stages[kind] = append(stages[kind], item)
+	}
+	sort.Strings(unlisted)
+
+	for _, kind := range append(slices.Clone(c.opts.KindOrder), unlisted...) {
+		if len(stages[kind]) == 0 {
+			continue
+		}
+		c.infof("restarting %d resources of kind: %s", len(stages[kind]), kind)
+		// once interrupted every remaining stage is reported as not attempted
+		c.restartWorkItems(ctx, sum, stages[kind])
+	}
+}
+
+// restartWorkItems restarts up to -concurrency work items at a time. Once the context is cancelled
+// no new work items are started, in-flight restarts get -drain-timeout to reach a safe point and
+// everything that was never started is reported as not attempted.
+func (c *kubeClient) restartWorkItems(ctx context.Context, sum *summary, items []workItem) {
+	// in-flight restarts run on their own context so a shutdown signal doesn't abandon a
+	// recreated pod halfway, it's only cancelled once the drain timeout runs out
+	workCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			select {
+			case <-done:
+			case <-time.After(c.opts.DrainTimeout):
+				cancel()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.opts.Concurrency)
+
+	// We'll use the RestartedAt annotation for higher level resources, and then duplicate a Pod
+	// spec with a new randomized name suffix
+	// https://github.com/kubernetes/kubectl/blob/master/pkg/cmd/rollout/rollout.go
+	// https://kubernetes.io/docs/reference/labels-annotations-taints/#kubectl-k8s-io-restart-at
+	for i, item := range items {
+		if !c.acquire(ctx, sem, i > 0) {
+			c.infof("shutting down, %d resources not attempted", len(items)-i)
+			notAttempted(sum, items[i:])
+			break
+		}
+		if c.stopped(sum) {
+			<-sem
+			c.infof("stopping after the first error, %d resources not attempted", len(items)-i)
+			notAttempted(sum, items[i:])
+			break
+		}
+
+		wg.Add(1)
+		go func(item workItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			c.infof("executing graceful restart on %s: %s in namespace: %s for: %s", item.kind, item.name, item.namespace, item.source())
+			if err := c.restartWorkItem(workCtx, sum, item); err != nil {
+				sum.fail(item.source(), item.namespace, err)
+			}
+		}(item)
+	}
+
+	wg.Wait()
+}
+
+// acquire waits for a free restart slot, the configured delay when paced and the cluster to be
+// out of pressure with the adaptive throttle, returning false when the context is cancelled first
+func (c *kubeClient) acquire(ctx context.Context, sem chan struct{}, paced bool) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case sem <- struct{}{}:
+	}
+
+	if paced && c.opts.Delay > 0 {
+		timer := time.NewTimer(c.opts.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+	}
+
+	c.waitForCapacity(ctx)
+
+	// prefer stopping over starting more work when both were ready
+	if ctx.Err() != nil {
+		<-sem
+		return false
+	}
+	return true
+}
+
+// stopped reports whether -stop-on-error applies because a restart already failed, remaining work
+// items are then reported as not attempted
+func (c *kubeClient) stopped(sum *summary) bool {
+	return c.opts.StopOnError && sum.failed()
+}
+
+// notAttempted reports work items that were never started
+func notAttempted(sum *summary, items []workItem) {
+	for _, item := range items {
+		sum.skipItem(item, SkipReasonNotAttempted)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+// waitForEndpoints waits until the recreated pod is a ready endpoint of every Service selecting
+// it, so the original pod is only removed once the new one actually receives traffic. Pods that
+// no Service selects don't need to wait.
+func (c *kubeClient) waitForEndpoints(ctx context.Context, name, namespace string) error {
+	pod, err := c.clientSet.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	services, err := c.clientSet.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var selecting []string
+	for _, svc := range services.Items {
+		if len(svc.Spec.Selector) > 0 && labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			selecting = append(selecting, svc.Name)
+		}
+	}
+	if len(selecting) == 0 {
+		c.infof("no service selects pod: %s in namespace: %s, not waiting for endpoints", name, namespace)
+		return nil
+	}
+
+	for _, svc := range selecting {
+		c.infof("waiting for pod: %s to become a ready endpoint of service: %s in namespace: %s", name, svc, namespace)
+		err := wait.PollUntilContextTimeout(ctx, ConfigRestartInterval*time.Second, c.opts.WaitTimeout, true, func(ctx context.Context) (bool, error) {
+			return c.isReadyEndpoint(ctx, svc, namespace, pod.Name)
+		})
+		if err != nil {
+			return fmt.Errorf("pod %s/%s not a ready endpoint of service %s: %w", namespace, name, svc, err)
+		}
+	}
+	return nil
+}
+
+// isReadyEndpoint reports whether the pod is a ready endpoint in any EndpointSlice of the Service
+func (c *kubeClient) isReadyEndpoint(ctx context.Context, service, namespace, podName string) (bool, error) {
+	endpointSlices, err := c.clientSet.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{discoveryv1.LabelServiceName: service}.String(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, slice := range endpointSlices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" || endpoint.TargetRef.Name != podName {
+				continue
+			}
+			// a nil ready condition means ready
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
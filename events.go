@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"sort"
+	"strings"
+	"time"
+)
+
+const MaxReportedWarnings = 3
+
+// podWarnings summarizes the most recent warning events of a pod, such as ImagePullBackOff or
+// FailedScheduling, for inclusion in an error message. It returns an empty string when there are
+// none or they can't be retrieved.
+func (c *kubeClient) podWarnings(ctx context.Context, pod *v1.Pod) string {
+	selector := fields.Set{
+		"involvedObject.kind":      "Pod",
+		"involvedObject.name":      pod.Name,
+		"involvedObject.namespace": pod.Namespace,
+		"involvedObject.uid":       string(pod.UID),
+		"type":                     v1.EventTypeWarning,
+	}.AsSelector().String()
+
+	events, err := c.clientSet.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil || len(events.Items) == 0 {
+		return ""
+	}
+
+	sort.Slice(events.Items, func(i, j int) bool {
+		return eventTime(events.Items[i]).After(eventTime(events.Items[j]))
+	})
+
+	var messages []string
+	for i, event := range events.Items {
+		if i == MaxReportedWarnings {
+			break
+		}
+		messages = append(messages, fmt.Sprintf("%s: %s", event.Reason, event.Message))
+	}
+	return fmt.Sprintf(" (recent warnings: %s)", strings.Join(messages, "; "))
+}
+
+// eventTime returns when the event was last seen, which depending on the reporter is stored in
+// different fields
+func eventTime(event v1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.FirstTimestamp.Time
+}
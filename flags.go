@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// EnvPrefix prefixes the environment variables flags fall back to, -match reads DB_RESTART_MATCH
+const EnvPrefix = "DB_RESTART_"
+
+// commandLineOnly are the flags that never fall back to the environment, the destructive ones and
+// those changing who the run acts as, what it trusts or which cluster it confirms. A stray variable
+// can't switch them on without any sign of it on the command line.
+var commandLineOnly = []string{"force-recreate", "delete-pods", "rollback", "insecure-skip-tls-verify", "as", "confirm-cluster"}
+
+// stringSlice is a flag.Value collecting every occurrence of a repeatable flag
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// envName is the environment variable a flag falls back to, -wait-timeout reads DB_RESTART_WAIT_TIMEOUT
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvDefaults sets every flag that wasn't given on the command line from its environment
+// variable, so flags take precedence over the environment and the environment over defaults.
+// Setting the variable of a command line only flag is an error.
+func applyEnvDefaults(flags *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if explicit[f.Name] || !ok || err != nil {
+			return
+		}
+		if slices.Contains(commandLineOnly, f.Name) {
+			err = fmt.Errorf("%s can't be set from the environment, pass -%s on the command line", envName(f.Name), f.Name)
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// usageError reports a bad flag value or combination the way flag.Parse reports an unknown flag,
+// with the usage and exit status 2, rather than crashing
+func usageError(err error) {
+	fmt.Fprintln(flag.CommandLine.Output(), err)
+	flag.Usage()
+	os.Exit(2)
+}
+
+// envUsage documents the environment variable fallback below the flag defaults
+func envUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nEvery flag not given on the command line falls back to an environment variable named %s\n", EnvPrefix)
+	fmt.Fprintf(out, "followed by the flag name in upper case with dashes as underscores, for example %s or %s.\n", envName("match"), envName("namespaces"))
+	fmt.Fprintf(out, "The flags -%s are only read from the command line.\n", strings.Join(commandLineOnly, ", -"))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const RunIDAnnotation = "figure.restart/run-id"
+
+// errRunCompleted is returned by the restart methods when a resource was already restarted by
+// the current run
+var errRunCompleted = errors.New("already restarted by this run")
+
+// errBelowMinReplicas is returned by the restart methods when a workload has too few replicas to
+// be restarted without downtime
+var errBelowMinReplicas = errors.New("fewer replicas than -min-replicas")
+
+// errAmbiguousOwner is returned with -strict-owner when an owner chain doesn't resolve to a
+// supported controller
+var errAmbiguousOwner = errors.New("owner can't be resolved to a supported controller")
+
+// errNoController is returned when the owner of a pod has nothing above it that a rollout can
+// restart, such as a ReplicaSet without a Deployment
+var errNoController = errors.New("no controller to restart")
+
+// errUnhealthy is returned with -require-healthy-before when a workload isn't fully ready, and
+// errHealthy with -unhealthy-only when it is
+var (
+	errUnhealthy = errors.New("not all replicas are ready")
+	errHealthy   = errors.New("all replicas are ready")
+)
+
+// healthGuard returns an error when the workload's readiness doesn't match the requested intent,
+// restarting an already struggling workload can turn a degraded service into an outage
+func (c *kubeClient) healthGuard(ready, desired int32) error {
+	switch {
+	case c.opts.RequireHealthy && ready < desired:
+		return fmt.Errorf("%w: %d of %d ready", errUnhealthy, ready, desired)
+	case c.opts.UnhealthyOnly && ready >= desired:
+		return fmt.Errorf("%w: %d of %d ready", errHealthy, ready, desired)
+	}
+	return nil
+}
+
+// MaxOwnerDepth bounds how many owner references are followed from a pod to its top level owner
+const MaxOwnerDepth = 8
+
+// errOwnerCycle is returned when following owner references leads back to an object already seen,
+// which only malformed owner references can do
+var errOwnerCycle = errors.New("owner reference cycle detected")
+
+// errOwnerGone is returned by the restart methods when the controller was deleted after its pods
+// were listed, there is nothing left to restart
+var errOwnerGone = errors.New("owner no longer exists")
+
+// ownerGone turns a NotFound error from reading a controller into errOwnerGone
+func ownerGone(err error, kind, name string) error {
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s %s", errOwnerGone, kind, name)
+	}
+	return err
+}
+
+// replicaGuard returns an error when the workload has fewer replicas than -min-replicas
+func (c *kubeClient) replicaGuard(kind string, meta metav1.ObjectMeta, replicas *int32) error {
+	count := replicaCount(replicas)
+	if int(count) >= c.opts.MinReplicas {
+		return nil
+	}
+
+	if c.opts.AllowFewerReplicas {
+		c.warnf("warning: restarting %s: %s in namespace: %s with only %d replicas", kind, meta.Name, meta.Namespace, count)
+		return nil
+	}
+	return fmt.Errorf("%w: %d", errBelowMinReplicas, count)
+}
+
+// restartGuard returns an error when the object shouldn't be restarted again
+func (c *kubeClient) restartGuard(meta metav1.ObjectMeta) error {
+	if c.opts.RunID != "" && meta.Annotations[RunIDAnnotation] == c.opts.RunID {
+		return errRunCompleted
+	}
+	if c.configUnchanged(meta) {
+		return errConfigUnchanged
+	}
+	return nil
+}
+
+// stampRestart records the annotations tracking this restart on the object
+func (c *kubeClient) stampRestart(meta *metav1.ObjectMeta) {
+	if c.opts.RunID != "" {
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]string)
+		}
+		meta.Annotations[RunIDAnnotation] = c.opts.RunID
+	}
+	c.stampConfigHash(meta)
+}
+
+// stampController records the run ID and ConfigMap hash on the controller for restarts that don't
+// stamp it as part of their own update, such as evicting its pods or a rollback patch
+func (c *kubeClient) stampController(ctx context.Context, resourceType, name, namespace string) error {
+	if c.opts.RunID == "" && c.configHash == "" {
+		return nil
+	}
+	options := metav1.UpdateOptions{FieldManager: c.opts.FieldManager}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		switch resourceType {
+		case "ReplicaSet":
+			rs, err := c.clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return ownerGone(err, resourceType, name)
+			}
+			c.stampRestart(&rs.ObjectMeta)
+			_, err = c.clientSet.AppsV1().ReplicaSets(namespace).Update(ctx, rs, options)
+			return err
+		case "Deployment":
+			deploy, err := c.clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return ownerGone(err, resourceType, name)
+			}
+			c.stampRestart(&deploy.ObjectMeta)
+			_, err = c.clientSet.AppsV1().Deployments(namespace).Update(ctx, deploy, options)
+			return err
+		case "StatefulSet":
+			sts, err := c.clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return ownerGone(err, resourceType, name)
+			}
+			c.stampRestart(&sts.ObjectMeta)
+			_, err = c.clientSet.AppsV1().StatefulSets(namespace).Update(ctx, sts, options)
+			return err
+		case "DaemonSet":
+			ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return ownerGone(err, resourceType, name)
+			}
+			c.stampRestart(&ds.ObjectMeta)
+			_, err = c.clientSet.AppsV1().DaemonSets(namespace).Update(ctx, ds, options)
+			return err
+		}
+		return nil
+	})
+}
+
+// skipReason maps the errors returned by restartGuard to the summary skip reason, or an empty
+// string for errors that are real failures
+func skipReason(err error) string {
+	switch {
+	case errors.Is(err, errRunCompleted):
+		return SkipReasonRunCompleted
+	case errors.Is(err, errConfigUnchanged):
+		return SkipReasonConfigUnchanged
+	case errors.Is(err, errBelowMinReplicas):
+		return SkipReasonMinReplicas
+	case errors.Is(err, errNoPreviousRevision):
+		return SkipReasonNoPreviousRevision
+	case errors.Is(err, errAmbiguousOwner):
+		return SkipReasonAmbiguousOwner
+	case errors.Is(err, errNoController):
+		return SkipReasonUnsupported
+	case errors.Is(err, errOwnerGone):
+		return SkipReasonOwnerGone
+	case errors.Is(err, errUnhealthy):
+		return SkipReasonUnhealthy
+	case errors.Is(err, errHealthy):
+		return SkipReasonHealthy
+	case errors.Is(err, errScaling):
+		return SkipReasonScaling
+	}
+	return ""
+}
+
+// replicaCount is the desired number of replicas, the API server defaults unset replicas to 1
+func replicaCount(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
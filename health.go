@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthState tracks the outcome of the most recent pass for the readiness probe
+type healthState struct {
+	ready atomic.Bool
+}
+
+// finishPass records whether a completed pass succeeded and returns its result
+func (c *kubeClient) finishPass(sum *summary) Result {
+	if c.opts.preview() {
+		return sum.result()
+	}
+	result := sum.result()
+	c.health.ready.Store(len(result.Errors) == 0)
+	return result
+}
+
+// serveHealth exposes /healthz and /readyz probes until the context is cancelled. The process is
+// live as long as it answers, and ready once the last pass finished without errors.
+func (c *kubeClient) serveHealth(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !c.health.ready.Load() {
+			http.Error(w, "last run did not succeed", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), CleanupTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		c.warnf("health server stopped: %s", err)
+	}
+}
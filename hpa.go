@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"time"
+)
+
+// errScaling is returned with -respect-hpa when a HorizontalPodAutoscaler targeting the workload
+// is scaling it or recently did
+var errScaling = errors.New("autoscaler is scaling")
+
+// hpaGuard returns an error when a HorizontalPodAutoscaler targeting the workload is scaling it, or
+// scaled it within -hpa-window. Restarting during a scale event makes the autoscaler react to the
+// restart's own churn.
+func (c *kubeClient) hpaGuard(ctx context.Context, kind string, meta metav1.ObjectMeta) error {
+	if !c.opts.RespectHPA {
+		return nil
+	}
+	hpas, err := c.clientSet.AutoscalingV2().HorizontalPodAutoscalers(meta.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, hpa := range hpas.Items {
+		target := hpa.Spec.ScaleTargetRef
+		if target.Kind != kind || target.Name != meta.Name || !isAppsGroup(target.APIVersion) {
+			continue
+		}
+		if hpa.Status.DesiredReplicas != hpa.Status.CurrentReplicas {
+			return fmt.Errorf("%w: %s is scaling from %d to %d replicas", errScaling, hpa.Name, hpa.Status.CurrentReplicas, hpa.Status.DesiredReplicas)
+		}
+		if last := hpa.Status.LastScaleTime; last != nil && time.Since(last.Time) < c.opts.HPAWindow {
+			return fmt.Errorf("%w: %s scaled %s ago, within -hpa-window", errScaling, hpa.Name, time.Since(last.Time).Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// isAppsGroup reports whether the API version is in the apps group of the workloads restarted,
+// a custom resource can reuse their kind names. Any version counts, they all serve the same object.
+func isAppsGroup(apiVersion string) bool {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	return err == nil && gv.Group == appsv1.GroupName
+}
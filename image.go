@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// imagePull compares the image a container of a recreated standalone pod runs with the image the
+// same container of the original pod ran
+type imagePull struct {
+	Container  string `json:"container"`
+	OldImageID string `json:"oldImageID"`
+	NewImageID string `json:"newImageID"`
+	Changed    bool   `json:"changed"`
+}
+
+func (p imagePull) describe() string {
+	if p.Changed {
+		return fmt.Sprintf("pulled a new image: %s", p.NewImageID)
+	}
+	return fmt.Sprintf("image unchanged: %s", p.NewImageID)
+}
+
+// verifyImagePull reports for every container whether the replacement runs another image digest
+// than the original pod did, confirming that the restart pulled a fresh image. Containers either
+// pod reports no image ID for yet are left out.
+func (c *kubeClient) verifyImagePull(ctx context.Context, original v1.Pod, replacement *v1.Pod) []imagePull {
+	if replacement == nil {
+		c.warnf("can't verify the image pull for pod: %s in namespace: %s, its replacement isn't running", original.Name, original.Namespace)
+		return nil
+	}
+	// the replacement we hold is the one returned on create, read the container statuses again
+	current, err := c.clientSet.CoreV1().Pods(replacement.Namespace).Get(ctx, replacement.Name, metav1.GetOptions{})
+	if err != nil {
+		c.warnf("can't verify the image pull for pod: %s in namespace: %s: %s", replacement.Name, replacement.Namespace, err)
+		return nil
+	}
+
+	previous := make(map[string]string, len(original.Status.ContainerStatuses))
+	for _, status := range original.Status.ContainerStatuses {
+		previous[status.Name] = status.ImageID
+	}
+
+	var pulls []imagePull
+	for _, status := range current.Status.ContainerStatuses {
+		old := previous[status.Name]
+		if old == "" || status.ImageID == "" {
+			continue
+		}
+		pull := imagePull{Container: status.Name, OldImageID: old, NewImageID: status.ImageID, Changed: old != status.ImageID}
+		if pull.Changed {
+			c.infof("container: %s of pod: %s in namespace: %s pulled a new image: %s, was: %s", status.Name, current.Name, current.Namespace, status.ImageID, old)
+		} else {
+			c.warnf("container: %s of pod: %s in namespace: %s still runs image: %s", status.Name, current.Name, current.Namespace, status.ImageID)
+		}
+		pulls = append(pulls, pull)
+	}
+	return pulls
+}
+
+// imageChanged reports whether a container of the pod runs an image other than the one in its
+// spec. Images pinned by digest are compared against the digest of the running image. Images
+// referenced by tag are only compared by name, the registry isn't queried, so a tag that was
+// pushed again with a new digest isn't detected unless the pod is set to pull it by digest.
+func imageChanged(pod v1.Pod) bool {
+	running := make(map[string]v1.ContainerStatus, len(pod.Status.ContainerStatuses))
+	for _, status := range pod.Status.ContainerStatuses {
+		running[status.Name] = status
+	}
+
+	for _, container := range pod.Spec.Containers {
+		status, ok := running[container.Name]
+		if !ok || status.ImageID == "" {
+			// nothing is running yet to compare against
+			continue
+		}
+		if _, digest, ok := strings.Cut(container.Image, "@"); ok {
+			if !strings.HasSuffix(status.ImageID, digest) {
+				return true
+			}
+			continue
+		}
+		if normalizeImage(status.Image) != normalizeImage(container.Image) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeImage strips the default registry and repository the runtime may report for images
+// referenced by their short name
+func normalizeImage(image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "library/")
+	if !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		image += ":latest"
+	}
+	return image
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// lineHandler is the default slog.Handler. It writes the message of every record, followed by its
+// attributes as key=value pairs, on a line of its own so the CLI reads the same as plain prints.
+type lineHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+	group string
+}
+
+func newLineHandler(out io.Writer, level slog.Leveler) *lineHandler {
+	return &lineHandler{mu: &sync.Mutex{}, out: out, level: level}
+}
+
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *lineHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	line.WriteString(record.Message)
+	for _, attr := range h.attrs {
+		fmt.Fprintf(&line, " %s=%v", attr.Key, attr.Value)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&line, " %s%s=%v", h.group, attr.Key, attr.Value)
+		return true
+	})
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, line.String())
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.group + attr.Key
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+// newLogger returns the default logger writing to stdout, debug messages are only shown verbose
+func newLogger(verbose bool) *slog.Logger {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	return slog.New(newLineHandler(os.Stdout, level))
+}
+
+// infof, warnf and debugf log a formatted message through the client's logger
+func (c *kubeClient) infof(format string, args ...any) {
+	c.log.Info(fmt.Sprintf(format, args...))
+}
+
+func (c *kubeClient) warnf(format string, args ...any) {
+	c.log.Warn(fmt.Sprintf(format, args...))
+}
+
+func (c *kubeClient) debugf(format string, args ...any) {
+	c.log.Debug(fmt.Sprintf(format, args...))
+}
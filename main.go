@@ -1,96 +1,700 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
-	"k8s.io/utils/strings/slices"
-	"path/filepath"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 const (
 	DatabaseMatch          = "database"
-	ValidNameMaxLength     = 255
 	WaitForRestartTimeout  = time.Duration(300 * time.Second)
 	ConfigRestartInterval  = 2
 	ConfigNameSuffixLength = 5
+	EvictionRetryInterval  = time.Duration(5 * time.Second)
+	EvictionRetrySteps     = 6
+	NotFoundRetryLimit     = 3
+	CleanupTimeout         = time.Duration(30 * time.Second)
+	DefaultFieldManager    = "figure-db-restarter"
+	RestartCountAnnotation = "figure.restart/count"
 )
 
+// Actions taken when a recreated standalone pod doesn't reach an accepted phase in time
+const (
+	// TimeoutActionFail leaves both pods in place and reports the restart as failed
+	TimeoutActionFail = "fail"
+	// TimeoutActionCleanup deletes the new pod, keeping the original, and reports the restart as failed
+	TimeoutActionCleanup = "cleanup"
+	// TimeoutActionKeep leaves both pods in place and reports the restart as successful
+	TimeoutActionKeep = "keep"
+	// TimeoutActionDeleteOld removes the original pod anyway, trusting the new pod to come up
+	TimeoutActionDeleteOld = "delete-old-anyway"
+)
+
+// DefaultClearAnnotations are stripped from recreated standalone pods unless -clear-annotations is
+// given. They record state of the original pod, such as its IP or injected sidecars, and copying
+// them makes the create fail or confuses the components that set them.
+var DefaultClearAnnotations = []string{
+	"cni.projectcalico.org/containerID",
+	"cni.projectcalico.org/podIP",
+	"cni.projectcalico.org/podIPs",
+	"k8s.v1.cni.cncf.io/network-status",
+	"kubernetes.io/psp",
+	"sidecar.istio.io/status",
+}
+
+// DefaultDropLabels are set by controllers on the pods they manage and are stripped from recreated
+// standalone pods, -keep-labels keeps them and -drop-labels adds to them
+var DefaultDropLabels = []string{
+	"controller-revision-hash",
+	"pod-template-hash",
+	"statefulset.kubernetes.io/pod-name",
+}
+
+// newPodSuffix generates the random suffix for recreated pod names, tests can replace it to get
+// predictable names
+var newPodSuffix = func() string {
+	return rand.String(ConfigNameSuffixLength - 1)
+}
+
+// errPodTerminated is returned while waiting on a recreated pod that stopped in a phase it can't leave
+var errPodTerminated = errors.New("terminated")
+
+// errRestartTimeout is returned when a recreated pod didn't reach an accepted phase in time
+var errRestartTimeout = errors.New("timed out")
+
 type kubeClient struct {
-	clientSet *kubernetes.Clientset
+	clientSet kubernetes.Interface
+	// config is needed for streaming calls such as exec that the clientset can't make on its own
+	config *rest.Config
+	opts   Options
+	// nodes caches node lookups so each node is only retrieved once per run
+	nodes map[string]*v1.Node
+	// configHash is the watched ConfigMap hash for the current pass
+	configHash string
+	// passStarted is stamped as restartedAt on every resource the current pass restarts, so
+	// -verify can tell this pass's restarts from earlier ones
+	passStarted time.Time
+	health      healthState
+	// cluster names the kubeconfig context and server the API server URL the client talks to
+	cluster string
+	server  string
+	// report streams outcomes as they happen with -report-format jsonl
+	report *reportStream
+	// log receives every message about the progress of the run
+	log *slog.Logger
+}
+
+type podError struct {
+	name         string
+	namespace    string
+	restartError error
+}
+
+// Error formats the failure with the pod, or resource, it belongs to so printing a podError or a
+// slice of them is readable
+func (e podError) Error() string {
+	if e.namespace != "" {
+		return fmt.Sprintf("pod %s/%s: %s", e.namespace, e.name, e.restartError)
+	}
+	return fmt.Sprintf("pod %s: %s", e.name, e.restartError)
+}
+
+func (e podError) Unwrap() error {
+	return e.restartError
 }
 
 func main() {
 	var k kubeClient
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-	}
+	var kubeconfigs stringSlice
+	flag.Var(&kubeconfigs, "kubeconfig", "(optional) path to a kubeconfig file, can be repeated to merge several, defaults to KUBECONFIG or ~/.kube/config")
+	flag.StringVar(&k.opts.Match, "match", DatabaseMatch, "(optional) term pod and workload names must contain when no selector is given")
+	contextName := flag.String("context", "", "(optional) kubeconfig context to use, defaults to the current context")
+	flag.BoolVar(&k.opts.SkipCordonedNodes, "skip-on-cordoned-nodes", false, "(optional) skip restarting pods scheduled on cordoned nodes")
+	flag.DurationVar(&k.opts.Interval, "interval", 0, "(optional) run continuously, restarting matching pods every interval")
+	flag.BoolVar(&k.opts.UseEviction, "use-eviction", false, "(optional) remove replaced standalone pods through the eviction API so PodDisruptionBudgets are honored")
+	flag.BoolVar(&k.opts.ReportSkipped, "report-matched-but-not-restarted", false, "(optional) list every matched pod that wasn't restarted with the filter that excluded it, the json report always does")
+	flag.StringVar(&k.opts.Output, "output", "text", "(optional) summary output format: text, wide or json, wide adds a table of every resource with its node and age")
+	flag.IntVar(&k.opts.BatchSize, "batch-size", 0, "(optional) restart resources in batches of this size, -concurrency applies within a batch, 0 for a single batch")
+	flag.DurationVar(&k.opts.BatchDelay, "batch-delay", 0, "(optional) how long to pause between batches with -batch-size")
+	flag.BoolVar(&k.opts.BatchWait, "batch-wait", false, "(optional) wait for every resource in a batch to roll out before the next batch, implies -wait")
+	flag.BoolVar(&k.opts.AnnotateOnly, "annotate-only", false, "(optional) only stamp the restartedAt annotation on controllers and leave the rollout to them, standalone pods are skipped instead of recreated")
+	flag.BoolVar(&k.opts.ForceRecreate, "force-recreate", false, "(optional) destructive: delete every controller and create it again from a snapshot, for changes a rollout can't make, requires -backup-dir and -confirm-cluster")
+	flag.BoolVar(&k.opts.EvictPods, "delete-pods", false, "(optional) restart controllers by evicting their pods one at a time instead of changing the pod template, for templates reverted by GitOps")
+	flag.BoolVar(&k.opts.RequireHealthy, "require-healthy-before", false, "(optional) skip controllers that don't have all replicas ready, so an already struggling workload isn't restarted")
+	flag.BoolVar(&k.opts.RespectHPA, "respect-hpa", false, "(optional) skip Deployments and StatefulSets whose HorizontalPodAutoscaler is scaling them or scaled them within -hpa-window")
+	flag.DurationVar(&k.opts.HPAWindow, "hpa-window", DefaultHPAWindow, "(optional) with -respect-hpa, how recently an autoscaler must have scaled a workload for its restart to be skipped")
+	flag.BoolVar(&k.opts.UnhealthyOnly, "unhealthy-only", false, "(optional) only restart controllers that don't have all replicas ready, the opposite of -require-healthy-before")
+	flag.IntVar(&k.opts.ThrottlePressuredNodes, "throttle-pressured-nodes", 0, "(optional) pause restarts while at least this many nodes report memory, disk or PID pressure, 0 to not check")
+	flag.IntVar(&k.opts.ThrottlePendingPods, "throttle-pending-pods", 0, "(optional) pause restarts while at least this many pods are pending across the cluster, 0 to not check")
+	flag.DurationVar(&k.opts.ThrottleInterval, "throttle-interval", 30*time.Second, "(optional) how long to pause before checking cluster pressure again with -throttle-pressured-nodes or -throttle-pending-pods")
+	flag.BoolVar(&k.opts.StopOnError, "stop-on-error", false, "(optional) start no further restarts once one has failed, the rest are reported as not attempted")
+	flag.BoolVar(&k.opts.Wait, "wait", false, "(optional) wait for each restarted controller to finish rolling out before continuing")
+	flag.BoolVar(&k.opts.WaitShared, "wait-shared-deadline", false, "(optional) wait for all restarted controllers, or each batch, to roll out concurrently under a single -wait-timeout and report which didn't, implies -wait")
+	flag.DurationVar(&k.opts.WaitTimeout, "wait-timeout", WaitForRestartTimeout, "(optional) how long to wait for a rollout or a recreated standalone pod to become ready")
+	flag.DurationVar(&k.opts.WatchTimeout, "watch-timeout", 30*time.Second, "(optional) how long a rollout wait waits to establish, or receive from, a watch before falling back to polling, 0 to always poll")
+	flag.BoolVar(&k.opts.Preflight, "preflight", false, "(optional) verify RBAC permissions for the run before restarting anything")
+	acceptPhases := flag.String("accept-phases", string(v1.PodRunning), "(optional) comma separated pod phases a recreated standalone pod must reach before the original is removed")
+	flag.StringVar(&k.opts.LabelSelector, "selector", "", "(optional) label selector used to find pods instead of matching -match in the name")
+	flag.StringVar(&k.opts.TenantLabel, "tenant-label", "", "(optional) key=value label of the tenant to restart pods of, shorthand for adding it to -selector that keeps matching -match in the name")
+	flag.StringVar(&k.opts.FieldSelector, "field-selector", "", "(optional) field selector used to find pods instead of matching -match in the name")
+	flag.DurationVar(&k.opts.DeleteTimeout, "delete-timeout", 2*time.Minute, "(optional) how long to wait for a replaced standalone pod to disappear, 0 to not wait")
+	namespaces := flag.String("namespaces", "", "(optional) comma separated namespaces to restart pods in, defaults to all namespaces")
+	flag.StringVar(&k.opts.NamespaceSelector, "namespace-selector", "", "(optional) label selector for the namespaces to restart pods in")
+	flag.IntVar(&k.opts.NamespaceParallel, "namespace-parallel", DefaultNamespaceParallel, "(optional) how many namespaces to list pods from concurrently")
+	flag.StringVar(&k.opts.ReportFile, "report-file", "", "(optional) also write the JSON report, including a timestamp and the invocation options, to this path, gzip compressed for a .gz path or stdout for -, with -contexts every cluster gets its own file named after the context")
+	flag.StringVar(&k.opts.ReportFormat, "report-format", "json", "(optional) format of -report-file: json for a single document written at the end, or jsonl to stream a line per resource as it completes")
+	watchConfigMap := flag.String("watch-configmap", "", "(optional) namespace/name of a ConfigMap, only restart workloads using it when its contents changed since their last restart")
+	ownerUID := flag.String("owner-uid", "", "(optional) only restart the resource with this UID, resolved through each pod's owner chain")
+	flag.DurationVar(&k.opts.Delay, "delay", 0, "(optional) pause before starting each resource's restart after the first, with -concurrency 1 this is the gap between one restart finishing and the next starting")
+	flag.IntVar(&k.opts.Limit, "limit", 0, "(optional) restart at most this many resources, 0 for no limit")
+	sample := flag.String("sample", "", "(optional) restart a deterministic fraction of the resources, e.g. 1/3 restarts every third")
+	flag.StringVar(&k.opts.Node, "node", "", "(optional) only restart matching pods scheduled on this node")
+	impersonate := flag.String("as", "", "(optional) username to impersonate for the run")
+	var impersonateGroups stringSlice
+	flag.Var(&impersonateGroups, "as-group", "(optional) group to impersonate for the run, can be repeated")
+	server := flag.String("server", "", "(optional) API server address to connect to directly, bypassing the kubeconfig")
+	clientCertificate := flag.String("client-certificate", "", "(optional) client certificate file for -server, requires -client-key")
+	clientKey := flag.String("client-key", "", "(optional) client key file for -server, requires -client-certificate")
+	certificateAuthority := flag.String("certificate-authority", "", "(optional) certificate authority file used to verify -server")
+	kubeconfigSecret := flag.String("kubeconfig-secret", "", "(optional) namespace/name of a Secret in the cluster the kubeconfig, or the in-cluster config, connects to, holding the kubeconfig of the cluster to restart in")
+	kubeconfigSecretKey := flag.String("kubeconfig-secret-key", DefaultKubeconfigSecretKey, "(optional) key of the kubeconfig in the -kubeconfig-secret data")
+	insecure := flag.Bool("insecure-skip-tls-verify", false, "(optional) don't verify the API server certificate, this makes connections insecure")
+	flag.BoolVar(&k.opts.ByWorkload, "by-workload", false, "(optional) find Deployments, StatefulSets and DaemonSets directly instead of through their pods, -selector and -field-selector apply to the workloads")
+	flag.StringVar(&k.opts.RunID, "run-id", "", "(optional) identifier for this logical run, resources already restarted under it are skipped so retries are safe")
+	flag.BoolVar(&k.opts.List, "list", false, "(optional) only list the matching pods with their restart counts and a count per namespace, nothing is restarted")
+	flag.BoolVar(&k.opts.SkipBarePods, "warn-bare-pods", false, "(optional) skip pods without an owning controller instead of recreating them with a warning")
+	flag.BoolVar(&k.opts.VerifyImagePull, "verify-image-pull", false, "(optional) compare the image IDs of a recreated standalone pod against the original's and report whether the image digest changed")
+	flag.StringVar(&k.opts.TimeoutAction, "timeout-action", TimeoutActionFail, "(optional) what to do when a recreated standalone pod doesn't start in time: fail, cleanup (delete the new pod and fail), keep (leave both pods) or delete-old-anyway")
+	nameSeed := flag.Int64("name-seed", 0, "(optional) seed for the random suffix of recreated pod names so runs are reproducible, 0 for a random seed")
+	flag.IntVar(&k.opts.Concurrency, "concurrency", 1, "(optional) how many resources to restart at the same time")
+	flag.StringVar(&k.opts.PriorityOrder, "priority-order", "", "(optional) order restarts by the priority of the triggering pod: low-first restarts critical workloads last, high-first the other way round")
+	flag.DurationVar(&k.opts.DrainTimeout, "drain-timeout", 30*time.Second, "(optional) how long in-flight restarts may keep running after an interrupt before being cancelled")
+	flag.StringVar(&k.opts.FieldManager, "field-manager", DefaultFieldManager, "(optional) field manager recorded in managedFields for every change the tool makes")
+	healthAddr := flag.String("health-addr", "", "(optional) address to serve /healthz and /readyz probes on, e.g. :8080")
+	contexts := flag.String("contexts", "", "(optional) comma separated kubeconfig contexts to run against one after another, results are reported per cluster")
+	parallelContexts := flag.Bool("parallel-contexts", false, "(optional) run against all -contexts at the same time, their output is interleaved")
+	flag.IntVar(&k.opts.MinReplicas, "min-replicas", 0, "(optional) skip Deployments and StatefulSets with fewer replicas than this, since restarting them means downtime")
+	flag.BoolVar(&k.opts.AllowFewerReplicas, "allow-single-replica", false, "(optional) restart workloads below -min-replicas anyway, with a warning")
+	flag.BoolVar(&k.opts.Verbose, "verbose", false, "(optional) print more detail, such as which matched pods led to each restarted resource")
+	flag.StringVar(&k.opts.TargetKind, "target-kind", "", "(optional) kind of the single object to restart without discovery: Deployment, StatefulSet, DaemonSet, ReplicaSet or Pod, requires -target-name and -target-namespace")
+	flag.StringVar(&k.opts.TargetName, "target-name", "", "(optional) name of the single object to restart, see -target-kind")
+	flag.StringVar(&k.opts.TargetNamespace, "target-namespace", "", "(optional) namespace of the single object to restart, see -target-kind")
+	flag.StringVar(&k.opts.ContainerName, "container-name", "", "(optional) only match pods with a container of this name, combined with the name match or selectors")
+	flag.BoolVar(&k.opts.IncludeInitContainers, "include-init-containers", false, "(optional) let -container-name also match init and ephemeral containers")
+	flag.StringVar(&k.opts.MatchExec, "match-exec", "", "(optional) program deciding on every selected pod: it gets the pod as JSON on stdin, exit code 0 matches and any other skips the pod")
+	flag.DurationVar(&k.opts.MatchExecTimeout, "match-exec-timeout", DefaultMatchExecTimeout, "(optional) how long a single -match-exec run may take before the pod is recorded as failed")
+	flag.IntVar(&k.opts.MatchExecParallel, "match-exec-parallel", DefaultMatchExecParallel, "(optional) how many -match-exec programs to run concurrently")
+	flag.BoolVar(&k.opts.Rollback, "rollback", false, "(optional) roll the matched workloads back to their previous revision instead of restarting them, like kubectl rollout undo")
+	flag.StringVar(&k.opts.PVC, "pvc", "", "(optional) only restart matching pods mounting the PersistentVolumeClaim with this name")
+	apiTimeout := flag.Duration("api-timeout", 0, "(optional) deadline for each individual API call, watches excluded, 0 for no deadline")
+	flag.StringVar(&k.opts.BackupDir, "backup-dir", "", "(optional) directory to write the YAML of every resource to before changing it, for manual recovery")
+	flag.BoolVar(&k.opts.Reactive, "watch-namespace-events", false, "(optional) watch pods and restart the owner of any matching pod stuck in CrashLoopBackOff as soon as it is seen")
+	flag.DurationVar(&k.opts.ReactiveDebounce, "debounce", 10*time.Minute, "(optional) with -watch-namespace-events, the minimum time between restarts of the same owner")
+	flag.IntVar(&k.opts.CrashLoopRestarts, "crashloop-restarts", 3, "(optional) with -watch-namespace-events, how many restarts a crash looping container needs before its owner is restarted")
+	flag.BoolVar(&k.opts.StrictOwner, "strict-owner", false, "(optional) skip pods whose owners don't resolve to exactly one supported controller instead of restarting what can be resolved")
+	var clearAnnotations stringSlice
+	flag.Var(&clearAnnotations, "clear-annotations", "(optional) annotation key to strip when recreating a standalone pod, can be repeated and replaces the defaults, pass an empty value to keep every annotation (default "+strings.Join(DefaultClearAnnotations, ", ")+")")
+	flag.BoolVar(&k.opts.Explain, "explain", false, "(optional) print why each pod would or wouldn't be restarted, nothing is restarted")
+	var keepLabels, dropLabels stringSlice
+	flag.Var(&keepLabels, "keep-labels", "(optional) label key to keep when recreating a standalone pod even though it is controller managed, can be repeated")
+	flag.Var(&dropLabels, "drop-labels", "(optional) additional label key to strip when recreating a standalone pod, can be repeated (always stripped: "+strings.Join(DefaultDropLabels, ", ")+")")
+	parallelKinds := flag.String("parallel-kinds", "", "(optional) comma separated kinds, e.g. Deployment,StatefulSet,DaemonSet,Pod, restart all resources of one kind before starting the next, kinds not listed go last")
+	flag.BoolVar(&k.opts.ConfirmCluster, "confirm-cluster", false, "(optional) ask for the context name to be typed before running against a cluster")
+	condition := flag.String("condition", "", "(optional) only restart pods with this condition, as type=status such as Ready=False")
+	flag.DurationVar(&k.opts.ConditionAge, "condition-age", 0, "(optional) with -condition, how long the pod must have been in the condition")
+	workloadAnnotation := flag.String("workload-annotation", "", "(optional) with -by-workload, only restart workloads annotated with key=value instead of matching -match in the name")
+	flag.BoolVar(&k.opts.OnlyIfImageChanged, "only-if-image-changed", false, "(optional) only restart pods running an image other than their spec, digests are compared for images pinned by digest, tags are not resolved against the registry so a tag pushed again with a new digest is not detected")
+	flag.StringVar(&k.opts.TimestampFormat, "timestamp-format", time.RFC3339, "(optional) Go time layout of the restartedAt annotation value, or unix for seconds since the epoch")
+	flag.BoolVar(&k.opts.RestartCount, "restart-count", false, "(optional) increment the "+RestartCountAnnotation+" annotation on the pod template of every restarted workload")
+	var excludeOwnerNames stringSlice
+	flag.Var(&excludeOwnerNames, "exclude-owner-name", "(optional) never restart pods whose top level owner has this name, e.g. the Deployment rather than its ReplicaSet, can be repeated")
+	var excludeKinds stringSlice
+	flag.Var(&excludeKinds, "exclude-kind", "(optional) never restart pods whose top level owner is of this kind: Deployment, StatefulSet, DaemonSet, ReplicaSet or Pod for standalone pods, can be repeated")
+	flag.BoolVar(&k.opts.Verify, "verify", false, "(optional) re-read every restarted resource afterwards and report restarts that didn't take effect")
+	flag.DurationVar(&k.opts.NamespaceDelay, "namespace-delay", 0, "(optional) restart one namespace at a time and pause this long between them, -concurrency and -parallel-kinds apply within each namespace")
+	flag.BoolVar(&k.opts.ProbeEndpoints, "probe-ready-via-endpoints", false, "(optional) only remove a replaced standalone pod once its replacement is a ready endpoint of every Service selecting it")
+	flag.BoolVar(&k.opts.Diff, "dry-run-diff", false, "(optional) show what the server would change for each restart using a server-side apply dry run, nothing is restarted")
+	flag.StringVar(&k.opts.Zone, "zone", "", "(optional) only restart matching pods on nodes in this topology.kubernetes.io/zone")
+	ownerKindPriority := flag.String("owner-kind-priority", "", "(optional) comma separated owner kinds in order of preference, pods with several owners only have the most preferred one restarted")
+	flag.StringVar(&k.opts.PostCreateExec, "post-create-exec", "", "(optional) shell command to run in a recreated standalone pod once it is ready, before the original is removed")
+	flag.DurationVar(&k.opts.PostCreateWait, "post-create-wait", 0, "(optional) how long to wait after a recreated standalone pod is ready, and -post-create-exec ran, before the original is removed")
+	flag.Usage = envUsage
 	flag.Parse()
+	if err := applyEnvDefaults(flag.CommandLine); err != nil {
+		usageError(err)
+	}
+	if *ownerKindPriority != "" {
+		k.opts.OwnerKindPriority = strings.Split(*ownerKindPriority, ",")
+	}
+	k.opts.ExcludeOwnerNames = excludeOwnerNames
+	k.opts.ExcludeKinds = excludeKinds
+
+	phases, err := parsePodPhases(*acceptPhases)
+	if err != nil {
+		usageError(err)
+	}
+	k.opts.AcceptPhases = phases
+
+	if *namespaces != "" {
+		k.opts.Namespaces = strings.Split(*namespaces, ",")
+	}
+	if *watchConfigMap != "" {
+		k.opts.ConfigMapNamespace, k.opts.ConfigMapName, err = parseObjectRef(*watchConfigMap)
+		if err != nil {
+			usageError(err)
+		}
+	}
+	k.opts.OwnerUID = types.UID(*ownerUID)
+	k.opts.ClearAnnotations = DefaultClearAnnotations
+	if len(clearAnnotations) > 0 {
+		k.opts.ClearAnnotations = clearAnnotations
+	}
+	for _, key := range append(slices.Clone(DefaultDropLabels), dropLabels...) {
+		if !slices.Contains(keepLabels, key) {
+			k.opts.DropLabels = append(k.opts.DropLabels, key)
+		}
+	}
+	if *sample != "" {
+		k.opts.SampleNum, k.opts.SampleDen, err = parseSample(*sample)
+		if err != nil {
+			usageError(err)
+		}
+	}
+	if *nameSeed != 0 {
+		rand.Seed(*nameSeed)
+	}
+	if *workloadAnnotation != "" {
+		var ok bool
+		k.opts.WorkloadAnnotationKey, k.opts.WorkloadAnnotationValue, ok = strings.Cut(*workloadAnnotation, "=")
+		if !ok || k.opts.WorkloadAnnotationKey == "" {
+			usageError(fmt.Errorf("expected key=value for -workload-annotation: %q", *workloadAnnotation))
+		}
+	}
+	if *condition != "" {
+		conditionType, conditionStatus, ok := strings.Cut(*condition, "=")
+		if !ok || conditionType == "" {
+			usageError(fmt.Errorf("expected type=status for -condition: %q", *condition))
+		}
+		k.opts.ConditionType, k.opts.ConditionStatus = v1.PodConditionType(conditionType), v1.ConditionStatus(conditionStatus)
+	}
+	if *parallelKinds != "" {
+		k.opts.KindOrder = strings.Split(*parallelKinds, ",")
+	}
+	if err := k.opts.Validate(); err != nil {
+		usageError(err)
+	}
+	k.log = newLogger(k.opts.Verbose)
+
+	conn := connectionOptions{
+		kubeconfigs:       kubeconfigs,
+		impersonate:       *impersonate,
+		impersonateGroups: impersonateGroups,
+		insecure:          *insecure,
+		apiTimeout:        *apiTimeout,
+
+		server:               *server,
+		clientCertificate:    *clientCertificate,
+		clientKey:            *clientKey,
+		certificateAuthority: *certificateAuthority,
 
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+		kubeconfigSecret:    *kubeconfigSecret,
+		kubeconfigSecretKey: *kubeconfigSecretKey,
+	}
+	if err := conn.validate(); err != nil {
+		usageError(err)
+	}
+	if *contexts != "" && (k.opts.Interval > 0 || k.opts.Reactive || conn.server != "") {
+		usageError(fmt.Errorf("-contexts can't be combined with -interval, -watch-namespace-events or -server"))
+	}
+
+	if k.opts.ReportFormat == "jsonl" {
+		k.report, err = openReportStream(k.opts.ReportFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		// os.Exit skips deferred calls, the exit paths below close the report themselves
+		defer k.report.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := conn.loadKubeconfigSecret(ctx); err != nil {
+		k.log.Error(err.Error())
+		k.report.Close()
+		os.Exit(1)
+	}
+
+	if *contexts != "" {
+		if err := runClusters(ctx, k.opts, conn, k.report, strings.Split(*contexts, ","), *parallelContexts); err != nil {
+			k.log.Error(err.Error())
+			k.report.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// use the selected or current context from the merged kubeconfigs, unless connecting directly
+	config, err := conn.restConfig(*contextName)
+	if err != nil {
+		panic(err.Error())
+	}
+	k.cluster, err = conn.resolveContext(*contextName)
 	if err != nil {
 		panic(err.Error())
 	}
+	k.server = config.Host
+	k.infof("using context: %s server: %s", k.cluster, k.server)
+	if k.opts.ConfirmCluster {
+		if err := confirmCluster(bufio.NewReader(os.Stdin), k.cluster, k.server); err != nil {
+			k.log.Error(err.Error())
+			k.report.Close()
+			os.Exit(1)
+		}
+	}
 
 	// create the clientset
+	k.config = config
 	k.clientSet, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	// It seems I cannot filter the lookup and must retrieve all PODs in the cluster as there are no
-	// known labels to select, and `database` can be anywhere in the name
-	// https://github.com/kubernetes/kubernetes/issues/72196
-	// https://github.com/kubernetes/kubernetes/issues/109400
-	pods, err := k.clientSet.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	if *healthAddr != "" {
+		go k.serveHealth(ctx, *healthAddr)
+	}
+
+	result, err := k.run(ctx)
 	if err != nil {
-		panic(err.Error())
+		k.log.Error(err.Error())
+		k.report.Close()
+		os.Exit(1)
 	}
 
-	type podError struct {
-		name         string
-		restartError error
+	// daemon and reactive mode report every pass as it finishes, list and explain mode only print
+	// the pods
+	if k.opts.Interval > 0 || k.opts.Reactive || k.opts.preview() {
+		return
+	}
+	k.printSummary(result)
+	if len(result.Errors) > 0 {
+		k.report.Close()
+		os.Exit(1)
 	}
+}
+
+// run performs the restarts selected by the options against the client's cluster and returns the
+// result of the pass without printing it, in daemon mode the result of the last pass is returned
+func (c *kubeClient) run(ctx context.Context) (Result, error) {
+	if c.opts.Preflight {
+		if err := c.preflight(ctx); err != nil {
+			return Result{}, err
+		}
+		c.infof("preflight passed")
+	}
+
+	// daemon mode keeps a local pod cache instead of listing every pod in the cluster each interval
+	if c.opts.Interval > 0 {
+		return c.runDaemon(ctx)
+	}
+
+	if c.opts.Reactive {
+		return c.runReactive(ctx)
+	}
+
+	if c.opts.targeted() {
+		return c.restartTarget(ctx)
+	}
+
+	if c.opts.ByWorkload {
+		return c.restartWorkloads(ctx)
+	}
+
+	pods, err := c.listPods(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return c.restartPods(ctx, pods), nil
+}
 
-	// instantiate vars for holding a list of errors and already restarted higher level resources
-	var allErrs []podError
-	var restarted []string
+// restartPods performs a single restart pass over the matching pods. In -list mode the pods are
+// printed and an empty result is returned.
+func (c *kubeClient) restartPods(ctx context.Context, pods []v1.Pod) Result {
+	// instantiate the summary holding errors, skips and already restarted higher level resources
+	sum := c.newSummary()
 
-	// We'll use the RestartedAt annotation for higher level resources, and then duplicate a Pod
-	// spec with a new randomized name suffix
-	// https://github.com/kubernetes/kubectl/blob/master/pkg/cmd/rollout/rollout.go
-	// https://kubernetes.io/docs/reference/labels-annotations-taints/#kubectl-k8s-io-restart-at
-	for _, pod := range pods.Items {
+	if err := c.preparePass(ctx); err != nil {
+		sum.fail(c.opts.ConfigMapName, c.opts.ConfigMapNamespace, err)
+		return c.finishPass(sum)
+	}
+
+	var selected []v1.Pod
+	for _, pod := range pods {
 		// skip anny pods without database in the name
-		if !strings.Contains(pod.Name, DatabaseMatch) {
+		if ok, _ := c.matchesPod(pod); !ok {
 			continue
 		}
+		sum.matched++
 
-		fmt.Printf("executing graceful restart on pod: %s\n", pod.Name)
-		err := k.restartResourceFromPod(context.TODO(), &restarted, pod)
-		if err != nil {
-			allErrs = append(allErrs, podError{pod.Name, err})
+		if c.opts.Node != "" && pod.Spec.NodeName != c.opts.Node {
+			sum.skip(pod, SkipReasonOtherNode)
 			continue
 		}
+
+		if c.opts.ConditionType != "" && !hasCondition(pod, c.opts.ConditionType, c.opts.ConditionStatus, c.opts.ConditionAge) {
+			sum.skip(pod, SkipReasonCondition)
+			continue
+		}
+
+		if c.opts.Zone != "" {
+			zone, err := c.nodeZone(ctx, pod.Spec.NodeName)
+			if err != nil {
+				sum.fail(pod.Name, pod.Namespace, err)
+				continue
+			}
+			if zone != c.opts.Zone {
+				sum.skip(pod, SkipReasonOtherZone)
+				continue
+			}
+		}
+
+		// avoid fighting node maintenance by leaving pods on cordoned nodes alone
+		if c.opts.SkipCordonedNodes {
+			cordoned, err := c.isNodeCordoned(ctx, pod.Spec.NodeName)
+			if err != nil {
+				sum.fail(pod.Name, pod.Namespace, err)
+				continue
+			}
+			if cordoned {
+				c.infof("skipping pod: %s on cordoned node: %s", pod.Name, pod.Spec.NodeName)
+				sum.skip(pod, SkipReasonCordoned)
+				continue
+			}
+		}
+
+		if c.opts.OwnerUID != "" {
+			owned, err := c.resolvesToUID(ctx, pod, c.opts.OwnerUID)
+			if err != nil {
+				sum.fail(pod.Name, pod.Namespace, err)
+				continue
+			}
+			if !owned {
+				sum.skip(pod, SkipReasonOwnerUID)
+				continue
+			}
+		}
+
+		if len(c.opts.ExcludeOwnerNames) > 0 || len(c.opts.ExcludeKinds) > 0 {
+			kind, owner, err := c.topOwner(ctx, pod)
+			if err != nil {
+				sum.fail(pod.Name, pod.Namespace, err)
+				continue
+			}
+			if slices.Contains(c.opts.ExcludeKinds, kind) {
+				c.infof("skipping pod: %s owned by excluded kind: %s", pod.Name, kind)
+				sum.skip(pod, SkipReasonExcludedKind)
+				continue
+			}
+			if slices.Contains(c.opts.ExcludeOwnerNames, owner) {
+				c.infof("skipping pod: %s owned by excluded: %s", pod.Name, owner)
+				sum.skip(pod, SkipReasonExcludedOwner)
+				continue
+			}
+		}
+
+		if c.opts.ConfigMapName != "" && !podUsesConfigMap(pod, c.opts.ConfigMapNamespace, c.opts.ConfigMapName) {
+			sum.skip(pod, SkipReasonConfigNotUsed)
+			continue
+		}
+
+		if c.opts.PVC != "" && !podMountsClaim(pod, c.opts.PVC) {
+			sum.skip(pod, SkipReasonPVCNotMounted)
+			continue
+		}
+
+		if c.opts.OnlyIfImageChanged && !imageChanged(pod) {
+			sum.skip(pod, SkipReasonImageUnchanged)
+			continue
+		}
+
+		selected = append(selected, pod)
+	}
+	if c.opts.MatchExec != "" {
+		selected = c.filterByExec(ctx, sum, selected)
 	}
 
-	if len(allErrs) > 0 {
-		fmt.Println(allErrs)
+	if c.opts.List {
+		c.printPodList(selected)
 	}
 
-	fmt.Printf("finished restarting %d resources: %s\n", len(restarted), restarted)
+	items := c.planWorkItems(sum, selected)
+	items = c.sampleWorkItems(sum, items)
+	c.orderByPriority(items)
+
+	if c.opts.Explain {
+		c.explainPods(pods, sum, items)
+	}
+	if c.opts.Diff {
+		c.diffWorkItems(ctx, items)
+	}
+	if c.opts.preview() {
+		return c.previewPass(sum, items)
+	}
+
+	c.restartStages(ctx, sum, items)
+	if c.opts.Verify {
+		c.verifyRestarts(ctx, sum)
+	}
+	return c.finishPass(sum)
+}
+
+// previewPass records the work items a preview would have restarted as dry run outcomes
+func (c *kubeClient) previewPass(sum *summary, items []workItem) Result {
+	for _, item := range items {
+		sum.target(item.key())
+		sum.recordPreview(restartedResource{
+			Kind:      item.kind,
+			Name:      item.name,
+			Namespace: item.namespace,
+			Images:    containerImages(item.pod.Spec),
+			Pod:       item.pod.Name,
+			Node:      item.pod.Spec.NodeName,
+			Created:   item.pod.CreationTimestamp.Time,
+		})
+	}
+	return c.finishPass(sum)
+}
+
+// matchesPod reports whether the pod is matched by name or selectors and -container-name, and why
+// not otherwise
+func (c *kubeClient) matchesPod(pod v1.Pod) (bool, string) {
+	if !c.usesSelectors() && !strings.Contains(pod.Name, c.opts.Match) {
+		return false, fmt.Sprintf("name doesn't contain %q", c.opts.Match)
+	}
+	if c.opts.ContainerName != "" && !hasContainer(pod.Spec, c.opts.ContainerName, c.opts.IncludeInitContainers) {
+		return false, fmt.Sprintf("no container named %q", c.opts.ContainerName)
+	}
+	return true, ""
+}
+
+// preparePass resets the per pass state
+func (c *kubeClient) preparePass(ctx context.Context) error {
+	// node state can change between passes, so only cache lookups for the current one
+	c.nodes = nil
+	c.passStarted = time.Now()
+
+	if c.opts.ConfigMapName != "" {
+		return c.loadConfigHash(ctx)
+	}
+	return nil
+}
+
+// labelSelector is the -selector to list with, narrowed to the -tenant-label tenant. The tenant
+// label doesn't replace matching on -match in the name the way -selector does.
+func (c *kubeClient) labelSelector() string {
+	if c.opts.TenantLabel == "" {
+		return c.opts.LabelSelector
+	}
+	if c.opts.LabelSelector == "" {
+		return c.opts.TenantLabel
+	}
+	return c.opts.LabelSelector + "," + c.opts.TenantLabel
+}
+
+// usesSelectors reports whether pods are found by selector rather than by name
+func (c *kubeClient) usesSelectors() bool {
+	return c.opts.LabelSelector != "" || c.opts.FieldSelector != ""
+}
+
+func (c *kubeClient) podListOptions() metav1.ListOptions {
+	return metav1.ListOptions{
+		LabelSelector: c.labelSelector(),
+		FieldSelector: c.opts.FieldSelector,
+	}
+}
+
+// resolvesToUID reports whether the pod itself or any resource in its owner chain has the UID
+func (c *kubeClient) resolvesToUID(ctx context.Context, pod v1.Pod, uid types.UID) (bool, error) {
+	if pod.UID == uid {
+		return true, nil
+	}
+
+	for _, ownerRef := range pod.OwnerReferences {
+		if ownerRef.UID == uid {
+			return true, nil
+		}
+		// Deployments are one step further up the chain through their ReplicaSet
+		if getOwnerResourceType(ownerRef) == "ReplicaSet" {
+			rs, err := c.clientSet.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			for _, rsOwnerRef := range rs.OwnerReferences {
+				if rsOwnerRef.UID == uid {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// topOwner returns the kind and name of the top level controller of the pod, the Deployment for
+// pods of a ReplicaSet, or kind Pod with an empty name for pods without a controller. ReplicaSets
+// are followed up to their controller, so malformed owner references pointing back into the chain
+// are reported as errOwnerCycle instead of being followed forever.
+func (c *kubeClient) topOwner(ctx context.Context, pod v1.Pod) (string, string, error) {
+	ownerRef := metav1.GetControllerOf(&pod)
+	if ownerRef == nil {
+		return "Pod", "", nil
+	}
+
+	visited := map[types.UID]bool{pod.UID: true}
+	for depth := 0; getOwnerResourceType(*ownerRef) == "ReplicaSet"; depth++ {
+		if visited[ownerRef.UID] || depth >= MaxOwnerDepth {
+			return "", "", fmt.Errorf("%w: pod %s/%s through %s %s", errOwnerCycle, pod.Namespace, pod.Name, ownerRef.Kind, ownerRef.Name)
+		}
+		visited[ownerRef.UID] = true
+
+		rs, err := c.clientSet.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", err
+		}
+		rsOwnerRef := metav1.GetControllerOf(rs)
+		if rsOwnerRef == nil {
+			return "ReplicaSet", rs.Name, nil
+		}
+		ownerRef = rsOwnerRef
+	}
+	if visited[ownerRef.UID] {
+		return "", "", fmt.Errorf("%w: pod %s/%s through %s %s", errOwnerCycle, pod.Namespace, pod.Name, ownerRef.Kind, ownerRef.Name)
+	}
+	return ownerRef.Kind, ownerRef.Name, nil
 }
 
 func getResourceType(name string) string {
@@ -112,103 +716,231 @@ func getResourceType(name string) string {
 	return resourceType
 }
 
-func (c *kubeClient) restartDeployment(ctx context.Context, name, namespace string) error {
+// getOwnerResourceType resolves an owner reference to a supported resource type. Only the apps/v1
+// controllers are supported, a custom resource reusing one of their kind names is not.
+func getOwnerResourceType(ownerRef metav1.OwnerReference) string {
+	if ownerRef.APIVersion != appsv1.SchemeGroupVersion.String() {
+		return "unsupported"
+	}
+	return getResourceType(ownerRef.Kind)
+}
+
+func (c *kubeClient) restartDeployment(ctx context.Context, name, namespace string) ([]string, error) {
 	deploy, err := c.clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return nil, ownerGone(err, "Deployment", name)
 	}
-
-	if deploy.Spec.Template.ObjectMeta.Annotations == nil {
-		deploy.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	if err := c.restartGuard(deploy.ObjectMeta); err != nil {
+		return nil, err
+	}
+	if err := c.replicaGuard("Deployment", deploy.ObjectMeta, deploy.Spec.Replicas); err != nil {
+		return nil, err
+	}
+	if err := c.healthGuard(deploy.Status.ReadyReplicas, replicaCount(deploy.Spec.Replicas)); err != nil {
+		return nil, err
+	}
+	if err := c.hpaGuard(ctx, "Deployment", deploy.ObjectMeta); err != nil {
+		return nil, err
+	}
+	if err := c.backupObject(deploy); err != nil {
+		return nil, err
 	}
-	deploy.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
 
-	_, err = c.clientSet.AppsV1().Deployments(namespace).Update(ctx, deploy, metav1.UpdateOptions{})
-	return err
+	// the restart count is read, modified and written, so retry with fresh state on conflicts
+	var images []string
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deploy, err := c.clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return ownerGone(err, "Deployment", name)
+		}
+		c.stampRestart(&deploy.ObjectMeta)
+		c.stampTemplate(&deploy.Spec.Template)
+
+		images = containerImages(deploy.Spec.Template.Spec)
+		_, err = c.clientSet.AppsV1().Deployments(namespace).Update(ctx, deploy, metav1.UpdateOptions{FieldManager: c.opts.FieldManager})
+		return err
+	})
+	return images, err
 }
 
-func (c *kubeClient) restartDaemonSet(ctx context.Context, name, namespace string) error {
+func (c *kubeClient) restartDaemonSet(ctx context.Context, name, namespace string) ([]string, error) {
 	ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return nil, ownerGone(err, "DaemonSet", name)
 	}
-
-	if ds.Spec.Template.ObjectMeta.Annotations == nil {
-		ds.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	if err := c.restartGuard(ds.ObjectMeta); err != nil {
+		return nil, err
+	}
+	if err := c.healthGuard(ds.Status.NumberReady, ds.Status.DesiredNumberScheduled); err != nil {
+		return nil, err
+	}
+	if err := c.backupObject(ds); err != nil {
+		return nil, err
 	}
-	ds.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
 
-	_, err = c.clientSet.AppsV1().DaemonSets(namespace).Update(ctx, ds, metav1.UpdateOptions{})
-	return err
+	var images []string
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return ownerGone(err, "DaemonSet", name)
+		}
+		c.stampRestart(&ds.ObjectMeta)
+		c.stampTemplate(&ds.Spec.Template)
+
+		images = containerImages(ds.Spec.Template.Spec)
+		_, err = c.clientSet.AppsV1().DaemonSets(namespace).Update(ctx, ds, metav1.UpdateOptions{FieldManager: c.opts.FieldManager})
+		return err
+	})
+	return images, err
 }
 
-func (c *kubeClient) restartStatefulSet(ctx context.Context, name, namespace string) error {
+func (c *kubeClient) restartStatefulSet(ctx context.Context, name, namespace string) ([]string, error) {
 	sts, err := c.clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return nil, ownerGone(err, "StatefulSet", name)
+	}
+	if err := c.restartGuard(sts.ObjectMeta); err != nil {
+		return nil, err
+	}
+	if err := c.replicaGuard("StatefulSet", sts.ObjectMeta, sts.Spec.Replicas); err != nil {
+		return nil, err
 	}
+	if err := c.healthGuard(sts.Status.ReadyReplicas, replicaCount(sts.Spec.Replicas)); err != nil {
+		return nil, err
+	}
+	if err := c.hpaGuard(ctx, "StatefulSet", sts.ObjectMeta); err != nil {
+		return nil, err
+	}
+	if err := c.backupObject(sts); err != nil {
+		return nil, err
+	}
+
+	var images []string
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		sts, err := c.clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return ownerGone(err, "StatefulSet", name)
+		}
+		c.stampRestart(&sts.ObjectMeta)
+		c.stampTemplate(&sts.Spec.Template)
 
-	if sts.Spec.Template.ObjectMeta.Annotations == nil {
-		sts.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+		images = containerImages(sts.Spec.Template.Spec)
+		_, err = c.clientSet.AppsV1().StatefulSets(namespace).Update(ctx, sts, metav1.UpdateOptions{FieldManager: c.opts.FieldManager})
+		return err
+	})
+	return images, err
+}
+
+// stampTemplate sets the restartedAt annotation that makes the controller roll out new pods, and
+// with -restart-count increments the count of restarts done by the tool
+func (c *kubeClient) stampTemplate(template *v1.PodTemplateSpec) {
+	if template.ObjectMeta.Annotations == nil {
+		template.ObjectMeta.Annotations = make(map[string]string)
 	}
-	sts.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = c.restartTimestamp()
 
-	_, err = c.clientSet.AppsV1().StatefulSets(namespace).Update(ctx, sts, metav1.UpdateOptions{})
-	return err
+	if c.opts.RestartCount {
+		// an unparsable count is treated as no previous restarts
+		count, _ := strconv.Atoi(template.ObjectMeta.Annotations[RestartCountAnnotation])
+		template.ObjectMeta.Annotations[RestartCountAnnotation] = strconv.Itoa(count + 1)
+	}
 }
 
-func (c *kubeClient) restartResourceFromPod(ctx context.Context, restarted *[]string, pod v1.Pod) error {
-	// retrieve owner references to identify supported restart resources
-	ownerRefs := pod.OwnerReferences
-	var resourceType string
-	if ownerRefs == nil || len(ownerRefs) == 0 {
-		resourceType = "Pod"
-		if err := c.restartResource(ctx, resourceType, "", pod); err != nil {
-			return err
+// restartWorkItem restarts the resource and records the outcome in the summary
+func (c *kubeClient) restartWorkItem(ctx context.Context, sum *summary, item workItem) error {
+	if item.resourceType == "Pod" {
+		if err := c.restartGuard(item.pod.ObjectMeta); err != nil {
+			c.infof("skipping pod: %s: %s", item.pod.Name, err)
+			sum.skipItem(item, skipReason(err))
+			return nil
 		}
-		*restarted = append(*restarted, pod.Name)
 	}
 
-	for _, ownerRef := range ownerRefs {
-		resourceType = getResourceType(ownerRef.Kind)
-		if resourceType == "unknown" {
-			fmt.Printf("skipping restart unknown resource type for pod: %s\n", pod.Name)
-			continue
-		}
+	sum.target(item.key())
 
-		match := fmt.Sprintf("%s|%s|%s", ownerRef.Name, ownerRef.Kind, pod.Namespace)
-		// ensure we don't keep restarting the same higher level resource
-		if resourceType != "Pod" {
-			if slices.Contains(*restarted, match) {
-				fmt.Printf("skipping already restarted resource: %s\n", match)
-				continue
-			}
-			if err := c.restartResource(ctx, resourceType, ownerRef.Name, pod); err != nil {
-				return err
-			}
-			*restarted = append(*restarted, match)
-		}
+	images, pulls, err := c.restartAndWait(ctx, item)
+	if reason := skipReason(err); reason != "" {
+		c.infof("skipping resource: %s: %s", item.key(), err)
+		sum.skipItem(item, reason)
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
+	c.debugf("restarted resource: %s for matched pods: %s", item.key(), strings.Join(item.pods, ", "))
+	sum.recordRestart(restartedResource{
+		Kind:       item.kind,
+		Name:       item.name,
+		Namespace:  item.namespace,
+		Images:     images,
+		Status:     StatusRestarted,
+		ImagePulls: pulls,
+		Pod:        item.pod.Name,
+		Node:       item.pod.Spec.NodeName,
+		Created:    item.pod.CreationTimestamp.Time,
+	})
 	return nil
 }
 
-func (c *kubeClient) restartReplicaSet(ctx context.Context, name, namespace string) error {
+// restartAndWait restarts the resource and with -wait waits for it to roll out. With
+// -verify-image-pull the image pulls of a recreated standalone pod are returned too.
+func (c *kubeClient) restartAndWait(ctx context.Context, item workItem) ([]string, []imagePull, error) {
+	if item.resourceType == "Pod" && c.opts.VerifyImagePull {
+		replacement, err := c.restartPod(ctx, item.pod)
+		if err != nil {
+			return nil, nil, fmt.Errorf("restarting %s %s/%s: %w", item.kind, item.namespace, item.name, err)
+		}
+		return containerImages(item.pod.Spec), c.verifyImagePull(ctx, item.pod, replacement), nil
+	}
+
+	images, err := c.restartResource(ctx, item.resourceType, item.name, item.pod)
+	if skipReason(err) != "" {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("restarting %s %s/%s: %w", item.kind, item.namespace, item.name, err)
+	}
+
+	// standalone pods are already running by the time restartPod returns
+	if c.opts.Wait && !c.opts.WaitShared && item.resourceType != "Pod" {
+		if err := c.waitForRollout(ctx, item.resourceType, item.name, item.namespace); err != nil {
+			return nil, nil, err
+		}
+	}
+	return images, nil, nil
+}
+
+func (c *kubeClient) restartReplicaSet(ctx context.Context, name, namespace string) ([]string, error) {
 	rs, err := c.clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return nil, ownerGone(err, "ReplicaSet", name)
 	}
 	var resourceType string
 	for _, ownerRef := range rs.OwnerReferences {
-		resourceType = getResourceType(ownerRef.Kind)
+		resourceType = getOwnerResourceType(ownerRef)
 		if resourceType == "Deployment" {
 			return c.restartDeployment(ctx, ownerRef.Name, namespace)
 		}
 	}
-	return nil
+	if c.opts.StrictOwner {
+		return nil, fmt.Errorf("%w: replicaset %s has no owning deployment", errAmbiguousOwner, name)
+	}
+	// a standalone replicaset doesn't roll out template changes, stamping it restarts nothing
+	return nil, fmt.Errorf("%w: replicaset %s has no owning deployment", errNoController, name)
 }
 
-func (c *kubeClient) restartResource(ctx context.Context, resourceType, name string, pod v1.Pod) error {
+func (c *kubeClient) restartResource(ctx context.Context, resourceType, name string, pod v1.Pod) ([]string, error) {
+	if c.opts.Rollback {
+		return c.rollbackResource(ctx, resourceType, name, pod.Namespace)
+	}
+	if c.opts.ForceRecreate && resourceType != "Pod" {
+		return c.forceRecreate(ctx, resourceType, name, pod.Namespace)
+	}
+	if c.opts.EvictPods && resourceType != "Pod" {
+		return c.evictManagedPods(ctx, resourceType, name, pod.Namespace)
+	}
+
 	switch resourceType {
 	case "ReplicaSet":
 		return c.restartReplicaSet(ctx, name, pod.Namespace)
@@ -219,71 +951,388 @@ func (c *kubeClient) restartResource(ctx context.Context, resourceType, name str
 	case "DaemonSet":
 		return c.restartDaemonSet(ctx, name, pod.Namespace)
 	case "Pod":
-		return c.restartPod(ctx, pod)
+		_, err := c.restartPod(ctx, pod)
+		return containerImages(pod.Spec), err
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (c *kubeClient) restartPod(ctx context.Context, pod v1.Pod) error {
-	suffix := rand.String(ConfigNameSuffixLength - 1)
-	var newPodName string
-	if len(pod.Name) > ValidNameMaxLength {
-		newPodName = newSuffixPodName(pod.Name[:len(pod.Name)-ConfigNameSuffixLength], suffix)
-	} else {
-		newPodName = newSuffixPodName(pod.Name, suffix)
+// restartPod recreates a standalone pod under a new name and removes the original once the
+// replacement is running. The replacement is returned when it reached an accepted phase.
+func (c *kubeClient) restartPod(ctx context.Context, pod v1.Pod) (*v1.Pod, error) {
+	// the original pod is deleted once replaced, keep a copy of it first
+	if err := c.backupObject(&pod); err != nil {
+		return nil, err
 	}
 
+	newPodName := newSuffixPodName(pod.Name, newPodSuffix())
+
 	newPod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      newPodName,
-			Namespace: pod.Namespace,
-			Labels:    pod.Labels,
+			Name:        newPodName,
+			Namespace:   pod.Namespace,
+			Labels:      withoutKeys(pod.Labels, c.opts.DropLabels),
+			Annotations: withoutKeys(pod.Annotations, c.opts.ClearAnnotations),
 		},
 		Spec: pod.Spec,
 	}
+	c.stampRestart(&newPod.ObjectMeta)
+	// follow the original naming scheme and let the API server generate the suffix
+	if pod.GenerateName != "" {
+		newPod.Name = ""
+		newPod.GenerateName = pod.GenerateName
+	}
 
-	instance, err := c.clientSet.CoreV1().Pods(newPod.Namespace).Create(ctx, newPod, metav1.CreateOptions{})
+	instance, err := c.clientSet.CoreV1().Pods(newPod.Namespace).Create(ctx, newPod, metav1.CreateOptions{FieldManager: c.opts.FieldManager})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	start := time.Now()
+	notFound := 0
 	for {
-		if time.Since(start) > WaitForRestartTimeout {
-			fmt.Printf("timed out waiting for Pod to restart: %s in namespace: %s\n", instance.Name, instance.Namespace)
-			break
+		if time.Since(start) > c.opts.WaitTimeout {
+			c.warnf("timed out waiting for Pod to restart: %s in namespace: %s", instance.Name, instance.Namespace)
+			return nil, c.handleRestartTimeout(ctx, pod, instance)
+		}
+		ready, err := c.isPodInAcceptablePhase(ctx, instance.Name, instance.Namespace, c.opts.AcceptPhases)
+		switch {
+		case apierrors.IsNotFound(err):
+			// tolerate a lagging read right after create, but a pod that stays missing is gone
+			notFound++
+			if notFound >= NotFoundRetryLimit {
+				return nil, fmt.Errorf("recreated pod %s/%s no longer exists%s: %w", instance.Namespace, instance.Name, c.podWarnings(ctx, instance), err)
+			}
+		case errors.Is(err, errPodTerminated):
+			// a pod that ran to completion will never reach the accepted phases, stop waiting
+			return nil, fmt.Errorf("recreated pod %s/%s %w%s", instance.Namespace, instance.Name, err, c.podWarnings(ctx, instance))
+		case err != nil:
+			c.warnf("failed checking pod: %s in namespace: %s, retrying: %s", instance.Name, instance.Namespace, err)
+		case ready:
+			if c.opts.ProbeEndpoints {
+				if err := c.waitForEndpoints(ctx, instance.Name, instance.Namespace); err != nil {
+					return nil, err
+				}
+			}
+			if err := c.postCreate(ctx, instance); err != nil {
+				return nil, err
+			}
+			c.infof("replacing pod: %s with %s in namespace %s", pod.Name, instance.Name, instance.Namespace)
+			return instance, c.removePod(ctx, pod)
+		default:
+			notFound = 0
 		}
-		if c.isPodRunning(ctx, instance.Name, instance.Namespace) {
-			fmt.Printf("replacing pod: %s with %s in namespace %s\n", pod.Name, instance.Name, instance.Namespace)
-			return c.deletePod(ctx, pod.Name, pod.Namespace)
+
+		select {
+		case <-ctx.Done():
+			// the original pod is still serving, don't leave a half finished replacement behind
+			cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), CleanupTimeout)
+			defer cancel()
+			if err := c.deletePod(cleanupCtx, instance.Name, instance.Namespace); err != nil {
+				c.warnf("failed cleaning up pod: %s in namespace: %s: %s", instance.Name, instance.Namespace, err)
+			}
+			return nil, fmt.Errorf("interrupted waiting for pod %s/%s: %w", instance.Namespace, instance.Name, ctx.Err())
+		case <-time.After(ConfigRestartInterval * time.Second):
+		}
+	}
+
+}
+
+// handleRestartTimeout applies the configured -timeout-action to a recreated pod that didn't reach
+// an accepted phase in time
+func (c *kubeClient) handleRestartTimeout(ctx context.Context, pod v1.Pod, instance *v1.Pod) error {
+	switch c.opts.TimeoutAction {
+	case TimeoutActionKeep:
+		return nil
+	case TimeoutActionDeleteOld:
+		c.infof("replacing pod: %s with %s in namespace %s despite timeout", pod.Name, instance.Name, instance.Namespace)
+		return c.removePod(ctx, pod)
+	}
+
+	// collect the events explaining why the pod didn't start before it may be deleted
+	warnings := c.podWarnings(ctx, instance)
+	if c.opts.TimeoutAction == TimeoutActionCleanup {
+		if err := c.deletePod(ctx, instance.Name, instance.Namespace); err != nil {
+			return fmt.Errorf("%w waiting for pod %s/%s%s, cleanup failed: %w", errRestartTimeout, instance.Namespace, instance.Name, warnings, err)
 		}
-		time.Sleep(ConfigRestartInterval * time.Second)
+		return fmt.Errorf("%w waiting for pod %s/%s%s, deleted it and kept %s", errRestartTimeout, instance.Namespace, instance.Name, warnings, pod.Name)
 	}
 
+	return fmt.Errorf("%w waiting for pod %s/%s after %s%s", errRestartTimeout, instance.Namespace, instance.Name, c.opts.WaitTimeout, warnings)
+}
+
+// removePod deletes or evicts the replaced pod and waits for it to be gone from the API
+func (c *kubeClient) removePod(ctx context.Context, pod v1.Pod) error {
+	var err error
+	if c.opts.UseEviction {
+		err = c.evictPod(ctx, pod.Name, pod.Namespace)
+	} else {
+		err = c.deletePod(ctx, pod.Name, pod.Namespace)
+	}
+	if err != nil || c.opts.DeleteTimeout == 0 {
+		return err
+	}
+
+	return c.waitForPodDeletion(ctx, pod)
+}
+
+// waitForPodDeletion waits for finalizers and the termination grace period to run their course
+func (c *kubeClient) waitForPodDeletion(ctx context.Context, pod v1.Pod) error {
+	err := wait.PollUntilContextTimeout(ctx, ConfigRestartInterval*time.Second, c.opts.DeleteTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := c.clientSet.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// a pod recreated under the same name is not the one we removed
+		return current.UID != pod.UID, nil
+	})
+	if err != nil {
+		return fmt.Errorf("replaced pod %s/%s still present after %s: %w", pod.Namespace, pod.Name, c.opts.DeleteTimeout, err)
+	}
 	return nil
 }
 
+// deletePod deletes a pod, a pod that is already gone counts as deleted
 func (c *kubeClient) deletePod(ctx context.Context, name, namespace string) error {
-	return c.clientSet.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	err := c.clientSet.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
 }
 
-func (c *kubeClient) isPodRunning(ctx context.Context, name, namespace string) bool {
+// evictPod removes a pod through the eviction subresource, retrying with backoff while a
+// PodDisruptionBudget refuses the eviction
+func (c *kubeClient) evictPod(ctx context.Context, name, namespace string) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	backoff := wait.Backoff{
+		Duration: EvictionRetryInterval,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    EvictionRetrySteps,
+	}
+
+	return retry.OnError(backoff, apierrors.IsTooManyRequests, func() error {
+		err := c.clientSet.CoreV1().Pods(namespace).EvictV1(ctx, eviction)
+		if apierrors.IsTooManyRequests(err) {
+			c.warnf("eviction of pod: %s in namespace: %s refused, retrying: %s", name, namespace, err)
+		}
+		return err
+	})
+}
+
+func (c *kubeClient) isPodInAcceptablePhase(ctx context.Context, name, namespace string, phases map[v1.PodPhase]bool) (bool, error) {
 	pod, err := c.clientSet.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return false
+		return false, err
 	}
 
-	switch pod.Status.Phase {
-	case v1.PodRunning:
-		return true
-	case v1.PodSucceeded, v1.PodFailed:
-		return false
+	phase := pod.Status.Phase
+	if !phases[phase] && (phase == v1.PodFailed || phase == v1.PodSucceeded) {
+		return false, fmt.Errorf("%w: %s%s", errPodTerminated, phase, terminationReason(pod))
 	}
+	return phases[phase], nil
+}
 
+// terminationReason describes why a pod stopped, from the pod status or its first terminated container
+func terminationReason(pod *v1.Pod) string {
+	if pod.Status.Reason != "" {
+		return fmt.Sprintf(" (%s: %s)", pod.Status.Reason, pod.Status.Message)
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil {
+			return fmt.Sprintf(" (container %s: %s, exit code %d)", status.Name, terminated.Reason, terminated.ExitCode)
+		}
+	}
+	return ""
+}
+
+// parsePodPhases converts a comma separated list of pod phases into a set
+func parsePodPhases(list string) (map[v1.PodPhase]bool, error) {
+	phases := make(map[v1.PodPhase]bool)
+	for _, name := range strings.Split(list, ",") {
+		phase := v1.PodPhase(strings.TrimSpace(name))
+		switch phase {
+		case v1.PodPending, v1.PodRunning, v1.PodSucceeded, v1.PodFailed, v1.PodUnknown:
+			phases[phase] = true
+		default:
+			return nil, fmt.Errorf("unsupported pod phase: %q", name)
+		}
+	}
+	return phases, nil
+}
+
+func (c *kubeClient) isNodeCordoned(ctx context.Context, name string) (bool, error) {
+	// pods that haven't been scheduled yet aren't on any node
+	if name == "" {
+		return false, nil
+	}
+
+	node, err := c.getNode(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return node.Spec.Unschedulable, nil
+}
+
+// nodeZone returns the topology.kubernetes.io/zone label of the node, empty for pods that haven't
+// been scheduled yet
+func (c *kubeClient) nodeZone(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	node, err := c.getNode(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return node.Labels[v1.LabelTopologyZone], nil
+}
+
+// getNode retrieves a node through the per pass cache
+func (c *kubeClient) getNode(ctx context.Context, name string) (*v1.Node, error) {
+	node, ok := c.nodes[name]
+	if !ok {
+		var err error
+		node, err = c.clientSet.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if c.nodes == nil {
+			c.nodes = make(map[string]*v1.Node)
+		}
+		c.nodes[name] = node
+	}
+	return node, nil
+}
+
+// containerImages returns the image of every container in the pod spec
+func containerImages(spec v1.PodSpec) []string {
+	images := make([]string, 0, len(spec.Containers))
+	for _, container := range spec.Containers {
+		images = append(images, container.Image)
+	}
+	return images
+}
+
+// podMountsClaim reports whether the pod has a volume backed by the named PersistentVolumeClaim
+func podMountsClaim(pod v1.Pod, claimName string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCondition reports whether the pod's condition of the given type has had the status for at
+// least minAge, a pod that doesn't report the condition at all doesn't have it
+func hasCondition(pod v1.Pod, conditionType v1.PodConditionType, status v1.ConditionStatus, minAge time.Duration) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == status && time.Since(condition.LastTransitionTime.Time) >= minAge
+		}
+	}
+	return false
+}
+
+// withoutKeys copies labels or annotations without the given keys
+func withoutKeys(values map[string]string, keys []string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		if !slices.Contains(keys, key) {
+			copied[key] = value
+		}
+	}
+	return copied
+}
+
+// hasContainer reports whether the pod spec has a container with the given name, with
+// includeInit its init and ephemeral containers are considered too
+func hasContainer(spec v1.PodSpec, name string, includeInit bool) bool {
+	for _, container := range spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	if !includeInit {
+		return false
+	}
+	for _, container := range spec.InitContainers {
+		if container.Name == name {
+			return true
+		}
+	}
+	for _, container := range spec.EphemeralContainers {
+		if container.Name == name {
+			return true
+		}
+	}
 	return false
 }
 
+// restartTimestamp formats the start of the current pass for the restartedAt annotation
+func (c *kubeClient) restartTimestamp() string {
+	now := c.passStarted
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if c.opts.TimestampFormat == "unix" {
+		return strconv.FormatInt(now.Unix(), 10)
+	}
+	return now.Format(c.opts.TimestampFormat)
+}
+
+// validateTimestampFormat rejects layouts without any time element, which would write the same
+// value on every restart and so never trigger a rollout
+func validateTimestampFormat(layout string) error {
+	if layout == "unix" {
+		return nil
+	}
+	reference := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	if layout == "" || reference.Format(layout) == reference.Add(time.Hour*24*400+time.Second).Format(layout) {
+		return fmt.Errorf("timestamp format has no time elements: %q", layout)
+	}
+	return nil
+}
+
+// resourceKey builds the kind|name|namespace key used to track restarted resources
+func resourceKey(kind, name, namespace string) string {
+	return fmt.Sprintf("%s|%s|%s", kind, name, namespace)
+}
+
+// newSuffixPodName appends the suffix to the name. The final DNS label of the name is truncated
+// to make room for the suffix, and the leading labels when the whole name would get too long, so
+// the result is still a valid DNS-1123 subdomain.
 func newSuffixPodName(name, suffix string) string {
-	return fmt.Sprintf("%s-%s", name, suffix)
+	prefix, label := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		prefix, label = name[:i+1], name[i+1:]
+	}
+	if max := validation.DNS1123LabelMaxLength - len(suffix) - 1; len(label) > max {
+		// labels must end with an alphanumeric character
+		label = strings.TrimRight(label[:max], "-")
+	}
+	last := fmt.Sprintf("%s-%s", label, suffix)
+	if max := validation.DNS1123SubdomainMaxLength - len(last); len(prefix) > max {
+		// keep the prefix ending in a full stop after an alphanumeric character
+		prefix = strings.TrimRight(prefix[:max-1], "-.")
+		if prefix != "" {
+			prefix += "."
+		}
+	}
+	return prefix + last
 }
@@ -0,0 +1,1179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client talking to a fake clientset holding the objects, with logging
+// discarded
+func newTestClient(opts Options, objects ...runtime.Object) (*kubeClient, *fake.Clientset) {
+	clientSet := fake.NewSimpleClientset(objects...)
+	return &kubeClient{
+		clientSet: clientSet,
+		opts:      opts,
+		nodes:     make(map[string]*v1.Node),
+		log:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, clientSet
+}
+
+// startPodsOnCreate makes pods created through the fake clientset report the Running phase right
+// away, there is no kubelet to start them
+func startPodsOnCreate(clientSet *fake.Clientset) {
+	clientSet.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*v1.Pod).Status.Phase = v1.PodRunning
+		return false, nil, nil
+	})
+}
+
+// runPass validates the options and runs a single restart pass over the pods listed from a fake
+// clientset holding the objects
+func runPass(t *testing.T, opts Options, objects ...runtime.Object) (Result, *fake.Clientset) {
+	t.Helper()
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	c, clientSet := newTestClient(opts, objects...)
+	pods, err := c.listPods(context.Background())
+	if err != nil {
+		t.Fatalf("listPods() error = %v", err)
+	}
+	return c.restartPods(context.Background(), pods), clientSet
+}
+
+// managedPod is a pod in the default namespace run by a Deployment of the same name through its
+// ReplicaSet, mutate adjusts the pod before it is stored
+func managedPod(name string, mutate func(*v1.Pod)) []runtime.Object {
+	owner := controllerRef("ReplicaSet", name)
+	pod := testPod(name+"-abcde", &owner)
+	if mutate != nil {
+		mutate(&pod)
+	}
+	deployOwner := controllerRef("Deployment", name)
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("Deployment-" + name)}}
+	return []runtime.Object{&pod, testReplicaSet(name, &deployOwner), deploy}
+}
+
+// controllerRef is a controller owner reference to an apps/v1 object
+func controllerRef(kind, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: appsv1.SchemeGroupVersion.String(),
+		Kind:       kind,
+		Name:       name,
+		UID:        types.UID(kind + "-" + name),
+		Controller: &controller,
+	}
+}
+
+// testReplicaSet is a ReplicaSet in the default namespace, controlled by owner when it is set
+func testReplicaSet(name string, owner *metav1.OwnerReference) *appsv1.ReplicaSet {
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("ReplicaSet-" + name)}}
+	if owner != nil {
+		rs.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	return rs
+}
+
+// outcomes maps each restarted resource to restarted and each skipped pod to the reason it was
+// skipped for
+func outcomes(result Result) map[string]string {
+	got := make(map[string]string)
+	for _, restarted := range result.Restarted {
+		got[resourceKey(restarted.Kind, restarted.Name, restarted.Namespace)] = "restarted"
+	}
+	for _, skipped := range result.Skipped {
+		got[skipped.Pod] = skipped.Reason
+	}
+	return got
+}
+
+// testPod is a pod in the default namespace, controlled by owner when it is set
+func testPod(name string, owner *metav1.OwnerReference) v1.Pod {
+	pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("Pod-" + name)}}
+	if owner != nil {
+		pod.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	return pod
+}
+
+func TestResourceKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      string
+		resource  string
+		namespace string
+		want      string
+	}{
+		{name: "bare pod", kind: "Pod", resource: "database-0", namespace: "default", want: "Pod|database-0|default"},
+		{name: "deployment", kind: "Deployment", resource: "database", namespace: "default", want: "Deployment|database|default"},
+		{name: "statefulset", kind: "StatefulSet", resource: "database", namespace: "default", want: "StatefulSet|database|default"},
+		{name: "other namespace", kind: "Deployment", resource: "database", namespace: "staging", want: "Deployment|database|staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resourceKey(tt.kind, tt.resource, tt.namespace); got != tt.want {
+				t.Errorf("resourceKey() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartPodGenerateName(t *testing.T) {
+	pod := testPod("database-abcde", nil)
+	pod.GenerateName = "database-"
+	c, clientSet := newTestClient(Options{AcceptPhases: map[v1.PodPhase]bool{v1.PodRunning: true}, WaitTimeout: time.Minute}, &pod)
+	startPodsOnCreate(clientSet)
+	var created v1.Pod
+	clientSet.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		newPod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod)
+		created = *newPod.DeepCopy()
+		// the fake clientset doesn't generate names like the API server does
+		newPod.Name = newPod.GenerateName + "fghij"
+		return false, nil, nil
+	})
+
+	instance, err := c.restartPod(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("restartPod() error = %v", err)
+	}
+	if created.Name != "" || created.GenerateName != pod.GenerateName {
+		t.Errorf("created pod with name %q and generateName %q, want generateName %q only", created.Name, created.GenerateName, pod.GenerateName)
+	}
+	if instance.Name != "database-fghij" {
+		t.Errorf("restartPod() = %q, want database-fghij", instance.Name)
+	}
+}
+
+func TestIsPodInAcceptablePhase(t *testing.T) {
+	tests := []struct {
+		name    string
+		phase   v1.PodPhase
+		missing bool
+		want    bool
+		wantErr func(error) bool
+	}{
+		{name: "running", phase: v1.PodRunning, want: true},
+		{name: "pending", phase: v1.PodPending},
+		{name: "failed", phase: v1.PodFailed, wantErr: func(err error) bool { return errors.Is(err, errPodTerminated) }},
+		{name: "not found", missing: true, wantErr: apierrors.IsNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod("database", nil)
+			pod.Status.Phase = tt.phase
+			var objects []runtime.Object
+			if !tt.missing {
+				objects = append(objects, &pod)
+			}
+			c, _ := newTestClient(Options{}, objects...)
+
+			got, err := c.isPodInAcceptablePhase(context.Background(), pod.Name, pod.Namespace, map[v1.PodPhase]bool{v1.PodRunning: true})
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !tt.wantErr(err) {
+				t.Fatalf("isPodInAcceptablePhase() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isPodInAcceptablePhase() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartPodGetErrors(t *testing.T) {
+	suffix := newPodSuffix
+	newPodSuffix = func() string { return "wxyz" }
+	t.Cleanup(func() { newPodSuffix = suffix })
+
+	notFound := apierrors.NewNotFound(v1.Resource("pods"), "database-wxyz")
+	transient := apierrors.NewInternalError(errors.New("etcd leader changed"))
+
+	tests := []struct {
+		name string
+		// errs are returned by the gets of the recreated pod in turn, later gets reach the clientset
+		errs      []error
+		wantErr   bool
+		wantTries int
+	}{
+		{
+			name:      "not found after create",
+			errs:      []error{notFound, notFound, notFound, notFound},
+			wantErr:   true,
+			wantTries: NotFoundRetryLimit,
+		},
+		{
+			name:      "lagging read after create",
+			errs:      []error{notFound},
+			wantTries: 2,
+		},
+		{
+			name:      "transient error",
+			errs:      []error{transient},
+			wantTries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// each retry waits for the poll interval, run the cases side by side
+			t.Parallel()
+			pod := testPod("database", nil)
+			c, clientSet := newTestClient(Options{AcceptPhases: map[v1.PodPhase]bool{v1.PodRunning: true}, WaitTimeout: time.Minute}, &pod)
+			startPodsOnCreate(clientSet)
+			tries := 0
+			clientSet.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.(k8stesting.GetAction).GetName() != "database-wxyz" {
+					return false, nil, nil
+				}
+				tries++
+				if tries <= len(tt.errs) {
+					return true, nil, tt.errs[tries-1]
+				}
+				return false, nil, nil
+			})
+
+			_, err := c.restartPod(context.Background(), pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restartPod() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !apierrors.IsNotFound(err) {
+				t.Errorf("restartPod() error = %v, want NotFound", err)
+			}
+			if tries != tt.wantTries {
+				t.Errorf("recreated pod was read %d times, want %d", tries, tt.wantTries)
+			}
+		})
+	}
+}
+
+func TestRestartPod(t *testing.T) {
+	suffix := newPodSuffix
+	newPodSuffix = func() string { return "wxyz" }
+	t.Cleanup(func() { newPodSuffix = suffix })
+
+	tests := []struct {
+		name string
+		pod  string
+		want string
+	}{
+		{
+			name: "short name",
+			pod:  "database",
+			want: "database-wxyz",
+		},
+		{
+			name: "long name",
+			pod:  "database-" + strings.Repeat("a", 54),
+			want: "database-" + strings.Repeat("a", 49) + "-wxyz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod(tt.pod, nil)
+			c, clientSet := newTestClient(Options{AcceptPhases: map[v1.PodPhase]bool{v1.PodRunning: true}, WaitTimeout: time.Minute}, &pod)
+			startPodsOnCreate(clientSet)
+
+			instance, err := c.restartPod(context.Background(), pod)
+			if err != nil {
+				t.Fatalf("restartPod() error = %v", err)
+			}
+			if instance.Name != tt.want {
+				t.Errorf("restartPod() created %q, want %q", instance.Name, tt.want)
+			}
+			if _, err := clientSet.CoreV1().Pods("default").Get(context.Background(), tt.want, metav1.GetOptions{}); err != nil {
+				t.Errorf("getting the recreated pod: %v", err)
+			}
+			if _, err := clientSet.CoreV1().Pods("default").Get(context.Background(), tt.pod, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("getting the original pod: error = %v, want NotFound", err)
+			}
+		})
+	}
+}
+
+func TestHasContainer(t *testing.T) {
+	spec := v1.PodSpec{
+		InitContainers:      []v1.Container{{Name: "migrate"}},
+		Containers:          []v1.Container{{Name: "exporter"}},
+		EphemeralContainers: []v1.EphemeralContainer{{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger"}}},
+	}
+
+	tests := []struct {
+		name        string
+		container   string
+		includeInit bool
+		want        bool
+	}{
+		{name: "container", container: "exporter", want: true},
+		{name: "init container", container: "migrate"},
+		{name: "init container included", container: "migrate", includeInit: true, want: true},
+		{name: "ephemeral container", container: "debugger"},
+		{name: "ephemeral container included", container: "debugger", includeInit: true, want: true},
+		{name: "missing", container: "postgres", includeInit: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasContainer(spec, tt.container, tt.includeInit); got != tt.want {
+				t.Errorf("hasContainer(%q) = %v, want %v", tt.container, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	ref := func(kind, name string) *metav1.OwnerReference {
+		owner := controllerRef(kind, name)
+		return &owner
+	}
+	replicas := int32(1)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default", UID: "Deployment-database"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	restarted := testPod("database-123-abcde", ref("ReplicaSet", "database-123"))
+	bare := testPod("database-standalone", nil)
+	unmatched := testPod("web-123-klmno", ref("ReplicaSet", "web-123"))
+
+	opts := Options{SkipBarePods: true}
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	c, clientSet := newTestClient(opts, deploy, testReplicaSet("database-123", ref("Deployment", "database")), &restarted, &bare, &unmatched)
+
+	result, err := c.run(context.Background())
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if result.Matched != 2 {
+		t.Errorf("Matched = %d, want 2", result.Matched)
+	}
+	if len(result.Errors) > 0 {
+		t.Errorf("Errors = %v, want none", result.Errors)
+	}
+	want := map[string]string{
+		"ReplicaSet|database-123|default": "restarted",
+		bare.Name:                         SkipReasonBarePod,
+	}
+	if got := outcomes(result); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+
+	updated, err := clientSet.AppsV1().Deployments("default").Get(context.Background(), "database", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting the restarted deployment: %v", err)
+	}
+	if _, ok := updated.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"]; !ok {
+		t.Errorf("deployment template annotations = %v, want restartedAt", updated.Spec.Template.Annotations)
+	}
+}
+
+func TestRestartPodTerminalPhase(t *testing.T) {
+	tests := []struct {
+		name   string
+		phase  v1.PodPhase
+		reason string
+	}{
+		{name: "failed", phase: v1.PodFailed, reason: "Evicted"},
+		{name: "succeeded", phase: v1.PodSucceeded, reason: "Completed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod("database", nil)
+			c, clientSet := newTestClient(Options{AcceptPhases: map[v1.PodPhase]bool{v1.PodRunning: true}, WaitTimeout: time.Minute}, &pod)
+			clientSet.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				newPod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod)
+				newPod.Status.Phase, newPod.Status.Reason = tt.phase, tt.reason
+				return false, nil, nil
+			})
+
+			start := time.Now()
+			_, err := c.restartPod(context.Background(), pod)
+			if !errors.Is(err, errPodTerminated) {
+				t.Fatalf("restartPod() error = %v, want %v", err, errPodTerminated)
+			}
+			if !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("restartPod() error = %v, want the reason %s", err, tt.reason)
+			}
+			if elapsed := time.Since(start); elapsed > ConfigRestartInterval*time.Second {
+				t.Errorf("restartPod() returned after %s, want without waiting", elapsed)
+			}
+			if _, err := clientSet.CoreV1().Pods("default").Get(context.Background(), pod.Name, metav1.GetOptions{}); err != nil {
+				t.Errorf("getting the original pod: %v, want it kept", err)
+			}
+		})
+	}
+}
+
+func TestRestartPodsPVC(t *testing.T) {
+	withClaim := func(claim string) func(*v1.Pod) {
+		return func(pod *v1.Pod) {
+			pod.Spec.Volumes = []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			}}
+		}
+	}
+	var objects []runtime.Object
+	objects = append(objects, managedPod("database-a", withClaim("data-a"))...)
+	objects = append(objects, managedPod("database-b", withClaim("data-b"))...)
+	objects = append(objects, managedPod("database-c", nil)...)
+
+	result, _ := runPass(t, Options{PVC: "data-a"}, objects...)
+	want := map[string]string{
+		"ReplicaSet|database-a|default": "restarted",
+		"database-b-abcde":              SkipReasonPVCNotMounted,
+		"database-c-abcde":              SkipReasonPVCNotMounted,
+	}
+	if got := outcomes(result); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+}
+
+func TestRestartPodsStrictOwner(t *testing.T) {
+	ref := func(kind, name string) *metav1.OwnerReference {
+		owner := controllerRef(kind, name)
+		return &owner
+	}
+	custom := controllerRef("Database", "database-custom")
+	custom.APIVersion = "example.com/v1"
+	customPod := testPod("database-custom-0", &custom)
+	standalone := testPod("database-standalone-abcde", ref("ReplicaSet", "database-standalone"))
+	objects := []runtime.Object{&customPod, &standalone, testReplicaSet("database-standalone", nil)}
+	objects = append(objects, managedPod("database", nil)...)
+
+	tests := []struct {
+		name   string
+		strict bool
+		want   map[string]string
+	}{
+		{
+			name: "lenient",
+			want: map[string]string{
+				"database-custom-0":           SkipReasonUnsupported,
+				"ReplicaSet|database|default": "restarted",
+				"database-standalone-abcde":   SkipReasonUnsupported,
+			},
+		},
+		{
+			name:   "strict",
+			strict: true,
+			want: map[string]string{
+				"database-custom-0":           SkipReasonAmbiguousOwner,
+				"ReplicaSet|database|default": "restarted",
+				"database-standalone-abcde":   SkipReasonAmbiguousOwner,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _ := runPass(t, Options{StrictOwner: tt.strict}, objects...)
+			if got := outcomes(result); !maps.Equal(got, tt.want) {
+				t.Errorf("outcomes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartPodClearAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		"cni.projectcalico.org/podIP": "10.0.0.1/32",
+		"sidecar.istio.io/status":     "{}",
+		"example.com/owner":           "team-db",
+	}
+
+	tests := []struct {
+		name  string
+		clear []string
+		want  map[string]string
+	}{
+		{
+			name:  "defaults",
+			clear: DefaultClearAnnotations,
+			want:  map[string]string{"example.com/owner": "team-db"},
+		},
+		{
+			name:  "overridden",
+			clear: []string{"example.com/owner"},
+			want: map[string]string{
+				"cni.projectcalico.org/podIP": "10.0.0.1/32",
+				"sidecar.istio.io/status":     "{}",
+			},
+		},
+		{
+			name: "none",
+			want: annotations,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod("database", nil)
+			pod.Annotations = annotations
+			c, clientSet := newTestClient(Options{AcceptPhases: map[v1.PodPhase]bool{v1.PodRunning: true}, WaitTimeout: time.Minute, ClearAnnotations: tt.clear}, &pod)
+			startPodsOnCreate(clientSet)
+
+			instance, err := c.restartPod(context.Background(), pod)
+			if err != nil {
+				t.Fatalf("restartPod() error = %v", err)
+			}
+			if !maps.Equal(instance.Annotations, tt.want) {
+				t.Errorf("recreated pod annotations = %v, want %v", instance.Annotations, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeletePod(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{name: "deleted"},
+		{name: "already gone", err: apierrors.NewNotFound(v1.Resource("pods"), "database")},
+		{name: "forbidden", err: apierrors.NewForbidden(v1.Resource("pods"), "database", errors.New("denied")), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod("database", nil)
+			c, clientSet := newTestClient(Options{}, &pod)
+			if tt.err != nil {
+				clientSet.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.err
+				})
+			}
+
+			err := c.deletePod(context.Background(), pod.Name, pod.Namespace)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deletePod() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartPodDropLabels(t *testing.T) {
+	labels := map[string]string{
+		"app":               "database",
+		"pod-template-hash": "5d8f7c",
+		"example.com/tier":  "primary",
+	}
+
+	tests := []struct {
+		name string
+		drop []string
+		want map[string]string
+	}{
+		{
+			name: "controller labels",
+			drop: DefaultDropLabels,
+			want: map[string]string{"app": "database", "example.com/tier": "primary"},
+		},
+		{
+			name: "additional label",
+			drop: append(slices.Clone(DefaultDropLabels), "example.com/tier"),
+			want: map[string]string{"app": "database"},
+		},
+		{
+			name: "kept controller labels",
+			want: labels,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod("database", nil)
+			pod.Labels = labels
+			c, clientSet := newTestClient(Options{AcceptPhases: map[v1.PodPhase]bool{v1.PodRunning: true}, WaitTimeout: time.Minute, DropLabels: tt.drop}, &pod)
+			startPodsOnCreate(clientSet)
+
+			instance, err := c.restartPod(context.Background(), pod)
+			if err != nil {
+				t.Fatalf("restartPod() error = %v", err)
+			}
+			if !maps.Equal(instance.Labels, tt.want) {
+				t.Errorf("recreated pod labels = %v, want %v", instance.Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSuffixPodName(t *testing.T) {
+	tests := []struct {
+		name string
+		pod  string
+		want string
+	}{
+		{
+			name: "short name",
+			pod:  "database",
+			want: "database-abcd",
+		},
+		{
+			name: "multiple labels",
+			pod:  "database.primary.example",
+			want: "database.primary.example-abcd",
+		},
+		{
+			name: "long final label",
+			pod:  "database." + strings.Repeat("a", 63),
+			want: "database." + strings.Repeat("a", 58) + "-abcd",
+		},
+		{
+			name: "long final label ending in a dash once truncated",
+			pod:  "database." + strings.Repeat("a", 57) + "-bbbbb",
+			want: "database." + strings.Repeat("a", 57) + "-abcd",
+		},
+		{
+			name: "long subdomain",
+			pod:  strings.Repeat(strings.Repeat("a", 62)+".", 4) + "b",
+			want: strings.Repeat(strings.Repeat("a", 62)+".", 3) + strings.Repeat("a", 57) + ".b-abcd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSuffixPodName(tt.pod, "abcd")
+			if got != tt.want {
+				t.Errorf("newSuffixPodName(%q) = %q, want %q", tt.pod, got, tt.want)
+			}
+			if errs := validation.IsDNS1123Subdomain(got); len(errs) > 0 {
+				t.Errorf("newSuffixPodName(%q) = %q is not a DNS-1123 subdomain: %v", tt.pod, got, errs)
+			}
+		})
+	}
+}
+
+func TestRestartPodsExcludeOwnerName(t *testing.T) {
+	ref := func(kind, name string) *metav1.OwnerReference {
+		owner := controllerRef(kind, name)
+		return &owner
+	}
+	primary := testPod("database-primary-123-abcde", ref("ReplicaSet", "database-primary-123"))
+	objects := []runtime.Object{&primary, testReplicaSet("database-primary-123", ref("Deployment", "database-primary"))}
+	objects = append(objects, managedPod("database-replica", nil)...)
+	objects = append(objects, managedPod("database-archive", nil)...)
+
+	result, _ := runPass(t, Options{ExcludeOwnerNames: []string{"database-primary", "database-archive"}}, objects...)
+	want := map[string]string{
+		"database-primary-123-abcde":          SkipReasonExcludedOwner,
+		"ReplicaSet|database-replica|default": "restarted",
+		"database-archive-abcde":              SkipReasonExcludedOwner,
+	}
+	if got := outcomes(result); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+}
+
+func TestRestartPodsZone(t *testing.T) {
+	node := func(name, zone string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: zone}}}
+	}
+	onNode := func(name string) func(*v1.Pod) {
+		return func(pod *v1.Pod) { pod.Spec.NodeName = name }
+	}
+	objects := []runtime.Object{node("node-a", "zone-a"), node("node-b", "zone-b")}
+	objects = append(objects, managedPod("database-a1", onNode("node-a"))...)
+	objects = append(objects, managedPod("database-a2", onNode("node-a"))...)
+	objects = append(objects, managedPod("database-b1", onNode("node-b"))...)
+
+	result, clientSet := runPass(t, Options{Zone: "zone-a"}, objects...)
+	want := map[string]string{
+		"ReplicaSet|database-a1|default": "restarted",
+		"ReplicaSet|database-a2|default": "restarted",
+		"database-b1-abcde":              SkipReasonOtherZone,
+	}
+	if got := outcomes(result); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+
+	nodeGets := 0
+	for _, action := range clientSet.Actions() {
+		if action.Matches("get", "nodes") {
+			nodeGets++
+		}
+	}
+	if nodeGets != 2 {
+		t.Errorf("nodes were read %d times, want once per node", nodeGets)
+	}
+}
+
+func TestGetOwnerResourceType(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		kind       string
+		want       string
+	}{
+		{apiVersion: "apps/v1", kind: "Deployment", want: "Deployment"},
+		{apiVersion: "apps/v1", kind: "StatefulSet", want: "StatefulSet"},
+		{apiVersion: "apps/v1", kind: "DaemonSet", want: "DaemonSet"},
+		{apiVersion: "apps/v1", kind: "ReplicaSet", want: "ReplicaSet"},
+		{apiVersion: "apps/v1", kind: "ControllerRevision", want: "unsupported"},
+		{apiVersion: "apps/v1beta2", kind: "Deployment", want: "unsupported"},
+		{apiVersion: "example.com/v1", kind: "Deployment", want: "unsupported"},
+		{apiVersion: "batch/v1", kind: "Job", want: "unsupported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.apiVersion+"/"+tt.kind, func(t *testing.T) {
+			got := getOwnerResourceType(metav1.OwnerReference{APIVersion: tt.apiVersion, Kind: tt.kind, Name: "database"})
+			if got != tt.want {
+				t.Errorf("getOwnerResourceType() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartPodsTenantLabel(t *testing.T) {
+	tenant := func(name string) func(*v1.Pod) {
+		return func(pod *v1.Pod) { pod.Labels = map[string]string{"tenant": name} }
+	}
+	var objects []runtime.Object
+	objects = append(objects, managedPod("database-acme", tenant("acme"))...)
+	objects = append(objects, managedPod("database-globex", tenant("globex"))...)
+	objects = append(objects, managedPod("cache-acme", tenant("acme"))...)
+	objects = append(objects, managedPod("database-shared", nil)...)
+
+	result, _ := runPass(t, Options{TenantLabel: "tenant=acme"}, objects...)
+	want := map[string]string{"ReplicaSet|database-acme|default": "restarted"}
+	if got := outcomes(result); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+}
+
+func TestRestartWorkItemOwnerGone(t *testing.T) {
+	kinds := []struct {
+		kind     string
+		resource string
+	}{
+		{kind: "Deployment", resource: "deployments"},
+		{kind: "DaemonSet", resource: "daemonsets"},
+		{kind: "StatefulSet", resource: "statefulsets"},
+		{kind: "ReplicaSet", resource: "replicasets"},
+	}
+	modes := []struct {
+		name string
+		opts Options
+	}{
+		{name: "template"},
+		{name: "evict", opts: Options{EvictPods: true}},
+		{name: "rollback", opts: Options{Rollback: true}},
+	}
+
+	for _, mode := range modes {
+		for _, tt := range kinds {
+			t.Run(mode.name+"/"+tt.kind, func(t *testing.T) {
+				c, clientSet := newTestClient(mode.opts)
+				// the owner was deleted after its pods were listed
+				clientSet.PrependReactor("get", tt.resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewNotFound(appsv1.Resource(tt.resource), "database")
+				})
+				owner := controllerRef(tt.kind, "database")
+				item := workItem{resourceType: tt.kind, kind: tt.kind, name: "database", namespace: "default", pod: testPod("database-abcde", &owner)}
+
+				sum := c.newSummary()
+				if err := c.restartWorkItem(context.Background(), sum, item); err != nil {
+					t.Fatalf("restartWorkItem() error = %v", err)
+				}
+				result := sum.result()
+				if len(result.Skipped) != 1 || result.Skipped[0].Reason != SkipReasonOwnerGone {
+					t.Errorf("Skipped = %+v, want the pod skipped with %s", result.Skipped, SkipReasonOwnerGone)
+				}
+				if len(result.Restarted) > 0 {
+					t.Errorf("Restarted = %+v, want none", result.Restarted)
+				}
+			})
+		}
+	}
+}
+
+func TestHasCondition(t *testing.T) {
+	notReady := func(since time.Duration) v1.Pod {
+		pod := testPod("database", nil)
+		pod.Status.Conditions = []v1.PodCondition{
+			{Type: v1.PodScheduled, Status: v1.ConditionTrue},
+			{Type: v1.PodReady, Status: v1.ConditionFalse, LastTransitionTime: metav1.NewTime(time.Now().Add(-since))},
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name          string
+		pod           v1.Pod
+		conditionType v1.PodConditionType
+		status        v1.ConditionStatus
+		minAge        time.Duration
+		want          bool
+	}{
+		{name: "type and status", pod: notReady(time.Minute), conditionType: v1.PodReady, status: v1.ConditionFalse, want: true},
+		{name: "other status", pod: notReady(time.Minute), conditionType: v1.PodReady, status: v1.ConditionTrue},
+		{name: "condition not reported", pod: notReady(time.Minute), conditionType: v1.ContainersReady, status: v1.ConditionFalse},
+		{name: "old enough", pod: notReady(10 * time.Minute), conditionType: v1.PodReady, status: v1.ConditionFalse, minAge: 5 * time.Minute, want: true},
+		{name: "too recent", pod: notReady(time.Minute), conditionType: v1.PodReady, status: v1.ConditionFalse, minAge: 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasCondition(tt.pod, tt.conditionType, tt.status, tt.minAge); got != tt.want {
+				t.Errorf("hasCondition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartPodsCondition(t *testing.T) {
+	ready := func(status v1.ConditionStatus) func(*v1.Pod) {
+		return func(pod *v1.Pod) {
+			pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: status, LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour))}}
+		}
+	}
+	var objects []runtime.Object
+	objects = append(objects, managedPod("database-stuck", ready(v1.ConditionFalse))...)
+	objects = append(objects, managedPod("database-ready", ready(v1.ConditionTrue))...)
+
+	result, _ := runPass(t, Options{ConditionType: v1.PodReady, ConditionStatus: v1.ConditionFalse, ConditionAge: 10 * time.Minute}, objects...)
+	want := map[string]string{
+		"ReplicaSet|database-stuck|default": "restarted",
+		"database-ready-abcde":              SkipReasonCondition,
+	}
+	if got := outcomes(result); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+}
+
+func TestRestartPodsExcludeKind(t *testing.T) {
+	agentOwner := controllerRef("DaemonSet", "database-agent")
+	agent := testPod("database-agent-abcde", &agentOwner)
+	standalone := testPod("database-standalone", nil)
+	objects := []runtime.Object{&agent, &standalone}
+	objects = append(objects, managedPod("database", nil)...)
+
+	result, _ := runPass(t, Options{ExcludeKinds: []string{"DaemonSet", "Pod"}}, objects...)
+	want := map[string]string{
+		"database-agent-abcde":        SkipReasonExcludedKind,
+		"database-standalone":         SkipReasonExcludedKind,
+		"ReplicaSet|database|default": "restarted",
+	}
+	if got := outcomes(result); !maps.Equal(got, want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+}
+
+func TestTopOwner(t *testing.T) {
+	ref := func(kind, name string) *metav1.OwnerReference {
+		owner := controllerRef(kind, name)
+		return &owner
+	}
+
+	// a chain of ReplicaSets one longer than topOwner follows
+	var chain []runtime.Object
+	for i := 0; i <= MaxOwnerDepth; i++ {
+		chain = append(chain, testReplicaSet(fmt.Sprintf("rs-%d", i), ref("ReplicaSet", fmt.Sprintf("rs-%d", i+1))))
+	}
+	chain = append(chain, testReplicaSet(fmt.Sprintf("rs-%d", MaxOwnerDepth+1), nil))
+
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		pod      v1.Pod
+		wantKind string
+		wantName string
+		wantErr  error
+	}{
+		{
+			name:     "bare pod",
+			pod:      testPod("web", nil),
+			wantKind: "Pod",
+		},
+		{
+			name:     "deployment",
+			objects:  []runtime.Object{testReplicaSet("web-123", ref("Deployment", "web"))},
+			pod:      testPod("web-123-abcde", ref("ReplicaSet", "web-123")),
+			wantKind: "Deployment",
+			wantName: "web",
+		},
+		{
+			name:     "standalone replicaset",
+			objects:  []runtime.Object{testReplicaSet("web", nil)},
+			pod:      testPod("web-abcde", ref("ReplicaSet", "web")),
+			wantKind: "ReplicaSet",
+			wantName: "web",
+		},
+		{
+			name: "replicaset cycle",
+			objects: []runtime.Object{
+				testReplicaSet("a", ref("ReplicaSet", "b")),
+				testReplicaSet("b", ref("ReplicaSet", "a")),
+			},
+			pod:     testPod("a-abcde", ref("ReplicaSet", "a")),
+			wantErr: errOwnerCycle,
+		},
+		{
+			name:    "deeper than the owner depth limit",
+			objects: chain,
+			pod:     testPod("rs-0-abcde", ref("ReplicaSet", "rs-0")),
+			wantErr: errOwnerCycle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestClient(Options{}, tt.objects...)
+			kind, name, err := c.topOwner(context.Background(), tt.pod)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("topOwner() error = %v, want %v", err, tt.wantErr)
+			}
+			if kind != tt.wantKind || name != tt.wantName {
+				t.Errorf("topOwner() = %s %s, want %s %s", kind, name, tt.wantKind, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestEvictManagedPodsStampsRunID(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default", UID: "Deployment-database"},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "database"}}},
+	}
+	c, clientSet := newTestClient(Options{EvictPods: true, RunID: "run-1"}, deploy)
+
+	if _, err := c.evictManagedPods(context.Background(), "Deployment", "database", "default"); err != nil {
+		t.Fatalf("evictManagedPods() error = %v", err)
+	}
+	updated, err := clientSet.AppsV1().Deployments("default").Get(context.Background(), "database", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting the deployment: %v", err)
+	}
+	if got := updated.Annotations[RunIDAnnotation]; got != "run-1" {
+		t.Errorf("run ID annotation = %q, want run-1", got)
+	}
+	if _, ok := updated.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"]; ok {
+		t.Errorf("template annotations = %v, want the template left alone", updated.Spec.Template.Annotations)
+	}
+
+	if _, err := c.evictManagedPods(context.Background(), "Deployment", "database", "default"); !errors.Is(err, errRunCompleted) {
+		t.Errorf("evictManagedPods() again error = %v, want %v", err, errRunCompleted)
+	}
+}
+
+func TestRollbackDeploymentStampsRunID(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "database"}}
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default", UID: "Deployment-database", Annotations: map[string]string{RevisionAnnotation: "2"}},
+		Spec:       appsv1.DeploymentSpec{Selector: selector},
+	}
+	owner := controllerRef("Deployment", "database")
+	previous := testReplicaSet("database-1", &owner)
+	previous.Labels = selector.MatchLabels
+	previous.Annotations = map[string]string{RevisionAnnotation: "1"}
+	previous.Spec.Template.Spec.Containers = []v1.Container{{Name: "postgres", Image: "postgres:15"}}
+	c, clientSet := newTestClient(Options{Rollback: true, RunID: "run-1"}, deploy, previous)
+
+	if _, err := c.rollbackResource(context.Background(), "Deployment", "database", "default"); err != nil {
+		t.Fatalf("rollbackResource() error = %v", err)
+	}
+	updated, err := clientSet.AppsV1().Deployments("default").Get(context.Background(), "database", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting the deployment: %v", err)
+	}
+	if got := updated.Annotations[RunIDAnnotation]; got != "run-1" {
+		t.Errorf("run ID annotation = %q, want run-1", got)
+	}
+	if images := containerImages(updated.Spec.Template.Spec); !slices.Equal(images, []string{"postgres:15"}) {
+		t.Errorf("template images = %v, want the previous revision's", images)
+	}
+
+	if _, err := c.rollbackResource(context.Background(), "Deployment", "database", "default"); !errors.Is(err, errRunCompleted) {
+		t.Errorf("rollbackResource() again error = %v, want %v", err, errRunCompleted)
+	}
+}
+
+func TestForceRecreateHealthGuard(t *testing.T) {
+	replicas := int32(2)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default", UID: "Deployment-database"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: 1},
+	}
+	c, clientSet := newTestClient(Options{ForceRecreate: true, RequireHealthy: true, BackupDir: t.TempDir()}, deploy)
+
+	if _, err := c.forceRecreate(context.Background(), "Deployment", "database", "default"); !errors.Is(err, errUnhealthy) {
+		t.Fatalf("forceRecreate() error = %v, want %v", err, errUnhealthy)
+	}
+	for _, action := range clientSet.Actions() {
+		if action.Matches("delete", "deployments") {
+			t.Errorf("forceRecreate() deleted the unhealthy deployment")
+		}
+	}
+}
+
+func TestRestartDeploymentConflictBacksUpOnce(t *testing.T) {
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default", UID: "Deployment-database"}}
+	dir := t.TempDir()
+	c, clientSet := newTestClient(Options{BackupDir: dir}, deploy)
+
+	// move the backup aside on the first update and fail it, a retry mustn't write another one
+	updates := 0
+	clientSet.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) != 1 {
+			t.Errorf("backups before the first update = %v, %v, want one", entries, err)
+		}
+		for _, entry := range entries {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+		return true, nil, apierrors.NewConflict(appsv1.Resource("deployments"), "database", errors.New("modified"))
+	})
+
+	if _, err := c.restartDeployment(context.Background(), "database", "default"); err != nil {
+		t.Fatalf("restartDeployment() error = %v", err)
+	}
+	if updates != 2 {
+		t.Errorf("deployment was updated %d times, want 2", updates)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("backups after the retry = %v, want none", entries)
+	}
+}
+
+func TestHPAGuard(t *testing.T) {
+	hpa := func(apiVersion, kind, name string, current, desired int32) runtime.Object {
+		return &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default"},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: apiVersion, Kind: kind, Name: name},
+			},
+			Status: autoscalingv2.HorizontalPodAutoscalerStatus{CurrentReplicas: current, DesiredReplicas: desired},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		hpa     runtime.Object
+		wantErr error
+	}{
+		{name: "scaling", hpa: hpa("apps/v1", "Deployment", "database", 2, 4), wantErr: errScaling},
+		{name: "settled", hpa: hpa("apps/v1", "Deployment", "database", 4, 4)},
+		{name: "other workload", hpa: hpa("apps/v1", "Deployment", "web", 2, 4)},
+		{name: "other kind", hpa: hpa("apps/v1", "StatefulSet", "database", 2, 4)},
+		{name: "custom resource of the same kind", hpa: hpa("example.com/v1", "Deployment", "database", 2, 4)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestClient(Options{RespectHPA: true}, tt.hpa)
+			meta := metav1.ObjectMeta{Name: "database", Namespace: "default"}
+			if err := c.hpaGuard(context.Background(), "Deployment", meta); !errors.Is(err, tt.wantErr) {
+				t.Errorf("hpaGuard() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartDeploymentConflictListsHPAsOnce(t *testing.T) {
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default", UID: "Deployment-database"}}
+	c, clientSet := newTestClient(Options{RespectHPA: true}, deploy)
+	conflicted := false
+	clientSet.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+		return true, nil, apierrors.NewConflict(appsv1.Resource("deployments"), "database", errors.New("modified"))
+	})
+
+	if _, err := c.restartDeployment(context.Background(), "database", "default"); err != nil {
+		t.Fatalf("restartDeployment() error = %v", err)
+	}
+	lists := 0
+	for _, action := range clientSet.Actions() {
+		if action.Matches("list", "horizontalpodautoscalers") {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("autoscalers were listed %d times, want once", lists)
+	}
+}
+
+func TestApplyEnvDefaultsCommandLineOnly(t *testing.T) {
+	for _, name := range commandLineOnly {
+		t.Run(name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			flags.String(name, "", "")
+			t.Setenv(envName(name), "true")
+			if err := applyEnvDefaults(flags); err == nil {
+				t.Errorf("applyEnvDefaults() with %s set, want an error", envName(name))
+			}
+		})
+	}
+}
+
+func TestLoadKubeconfigSecretOverrides(t *testing.T) {
+	var impersonated string
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		impersonated = r.Header.Get("Impersonate-User")
+		secret := v1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "spoke", Namespace: "clusters"},
+			Data:       map[string][]byte{DefaultKubeconfigSecretKey: []byte("spoke kubeconfig")},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(secret)
+	}))
+	t.Cleanup(hub.Close)
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	hubConfig := fmt.Sprintf("apiVersion: v1\nkind: Config\nclusters:\n- name: hub\n  cluster:\n    server: %s\ncontexts:\n- name: hub\n  context:\n    cluster: hub\n    user: hub\nusers:\n- name: hub\n  user: {}\ncurrent-context: hub\n", hub.URL)
+	if err := os.WriteFile(kubeconfig, []byte(hubConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := connectionOptions{
+		kubeconfigs:         []string{kubeconfig},
+		impersonate:         "auditor",
+		kubeconfigSecret:    "clusters/spoke",
+		kubeconfigSecretKey: DefaultKubeconfigSecretKey,
+	}
+	if err := o.loadKubeconfigSecret(context.Background()); err != nil {
+		t.Fatalf("loadKubeconfigSecret() error = %v", err)
+	}
+	if string(o.kubeconfigData) != "spoke kubeconfig" {
+		t.Errorf("kubeconfigData = %q, want the secret's kubeconfig", o.kubeconfigData)
+	}
+	if impersonated != "auditor" {
+		t.Errorf("hub request impersonated %q, want auditor", impersonated)
+	}
+}
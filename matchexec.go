@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// filterByExec narrows the selected pods down to the ones the -match-exec program accepts.
+//
+// The program is run once per pod, without a shell, with the pod as a JSON v1 Pod object on
+// stdin. Exit code 0 matches the pod and any other exit code skips it. A program that can't be
+// started, or doesn't exit within -match-exec-timeout, records a failure for the pod, with its
+// stderr in the error. At most -match-exec-parallel programs run at once.
+func (c *kubeClient) filterByExec(ctx context.Context, sum *summary, pods []v1.Pod) []v1.Pod {
+	matched := make([]bool, len(pods))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.opts.MatchExecParallel)
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			pod := pods[i]
+			match, err := c.execMatches(ctx, pod)
+			if err != nil {
+				sum.fail(pod.Name, pod.Namespace, fmt.Errorf("running -match-exec: %w", err))
+				return
+			}
+			if !match {
+				sum.skip(pod, SkipReasonExecNoMatch)
+				return
+			}
+			matched[i] = true
+		}(i)
+	}
+	wg.Wait()
+
+	// keep the listing order so the rest of the run is the same as without -match-exec
+	var selected []v1.Pod
+	for i, pod := range pods {
+		if matched[i] {
+			selected = append(selected, pod)
+		}
+	}
+	return selected
+}
+
+// execMatches runs the -match-exec program for a single pod
+func (c *kubeClient) execMatches(ctx context.Context, pod v1.Pod) (bool, error) {
+	// pods from a list carry no type, set it so the program gets a complete object
+	pod.APIVersion, pod.Kind = "v1", "Pod"
+	input, err := json.Marshal(pod)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.MatchExecTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.opts.MatchExec)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return false, fmt.Errorf("%s on pod %s/%s: %w", c.opts.MatchExec, pod.Namespace, pod.Name, ctx.Err())
+	case errors.As(err, &exitErr):
+		c.debugf("-match-exec rejected pod: %s in namespace: %s with exit code %d", pod.Name, pod.Namespace, exitErr.ExitCode())
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("%s on pod %s/%s: %w: %s", c.opts.MatchExec, pod.Namespace, pod.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return true, nil
+}
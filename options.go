@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"time"
+)
+
+// DefaultNamespaceParallel is how many namespaces are listed concurrently when unset
+const DefaultNamespaceParallel = 4
+
+// DefaultHPAWindow is how recently an autoscaler must have scaled a workload to skip it with
+// -respect-hpa when unset
+const DefaultHPAWindow = 5 * time.Minute
+
+// DefaultMatchExecParallel is how many -match-exec programs run concurrently when unset
+const DefaultMatchExecParallel = 4
+
+// DefaultMatchExecTimeout bounds a single -match-exec run when unset
+const DefaultMatchExecTimeout = 10 * time.Second
+
+// Options control a restart run. The CLI populates them from its flags, the zero value of every
+// field is a usable default that Validate fills in where needed.
+type Options struct {
+	// Matching. Without LabelSelector or FieldSelector pods are matched on Match in the name.
+	Match         string
+	LabelSelector string
+	// TenantLabel is a key=value label narrowing matches to one tenant on top of the name match
+	TenantLabel   string
+	FieldSelector string
+	// ContainerName only matches pods with a container of this name, IncludeInitContainers
+	// matches init and ephemeral containers of that name too
+	ContainerName         string
+	IncludeInitContainers bool
+	// MatchExec is a program that decides on every otherwise selected pod, see filterByExec.
+	// MatchExecTimeout bounds each run and MatchExecParallel how many run at once.
+	MatchExec         string
+	MatchExecTimeout  time.Duration
+	MatchExecParallel int
+	// ByWorkload discovers Deployments, StatefulSets and DaemonSets directly instead of pods
+	ByWorkload bool
+	// WorkloadAnnotationKey and WorkloadAnnotationValue select workloads by annotation with ByWorkload
+	WorkloadAnnotationKey   string
+	WorkloadAnnotationValue string
+	// TargetKind, TargetName and TargetNamespace restart exactly one object, skipping discovery
+	TargetKind      string
+	TargetName      string
+	TargetNamespace string
+
+	// Scope. Empty Namespaces and NamespaceSelector mean every namespace.
+	Namespaces        []string
+	NamespaceSelector string
+	// NamespaceParallel is how many namespaces pods are listed from concurrently
+	NamespaceParallel int
+
+	// Filters applied to matched pods, each skipped pod is reported with its reason
+	SkipCordonedNodes bool
+	Node              string
+	Zone              string
+	// ConditionType and ConditionStatus only keep pods with the condition in that status, for at
+	// least ConditionAge
+	ConditionType     v1.PodConditionType
+	ConditionStatus   v1.ConditionStatus
+	ConditionAge      time.Duration
+	OwnerUID          types.UID
+	ExcludeOwnerNames []string
+	// ExcludeKinds skips pods whose top level owner is of one of these kinds, Pod for standalone pods
+	ExcludeKinds []string
+	PVC          string
+	// ConfigMapNamespace and ConfigMapName identify the ConfigMap whose changes trigger restarts
+	ConfigMapNamespace string
+	ConfigMapName      string
+	// OnlyIfImageChanged compares tags by name only, a tag pushed again with a new digest is missed
+	OnlyIfImageChanged bool
+	// SkipBarePods skips pods without owners instead of only warning about them
+	SkipBarePods bool
+	// StrictOwner skips pods whose owners don't resolve to exactly one supported controller
+	StrictOwner bool
+	// OwnerKindPriority picks the owner to restart for pods with several
+	OwnerKindPriority []string
+	// MinReplicas skips Deployments and StatefulSets with fewer replicas, unless AllowFewerReplicas
+	// is set in which case they are only warned about
+	MinReplicas        int
+	AllowFewerReplicas bool
+	// RequireHealthy skips controllers without all replicas ready, UnhealthyOnly restarts only those
+	RequireHealthy bool
+	UnhealthyOnly  bool
+	// RespectHPA skips controllers whose autoscaler is scaling them or scaled them within HPAWindow
+	RespectHPA bool
+	HPAWindow  time.Duration
+
+	// Selection among the planned resources. SampleNum out of every SampleDen are restarted, and at
+	// most Limit when it is above 0.
+	SampleNum int
+	SampleDen int
+	Limit     int
+
+	// Strategy
+	Concurrency int
+	// Delay pauses before each restart after the first
+	Delay time.Duration
+	// PriorityOrder orders the restarts by the priority of the triggering pods when set
+	PriorityOrder string
+	// KindOrder restarts one kind at a time in this order when set
+	KindOrder []string
+	// NamespaceDelay restarts one namespace at a time with this pause in between when set
+	NamespaceDelay time.Duration
+	// BatchSize restarts the resources in batches of this size when set, pausing BatchDelay in
+	// between. BatchWait waits for every batch to roll out, the same as Wait.
+	BatchSize  int
+	BatchDelay time.Duration
+	BatchWait  bool
+	// ThrottlePressuredNodes and ThrottlePendingPods pause restarts, rechecking every
+	// ThrottleInterval, while at least this many nodes report memory, disk or PID pressure or this
+	// many pods are pending. Zero disables either check.
+	ThrottlePressuredNodes int
+	ThrottlePendingPods    int
+	ThrottleInterval       time.Duration
+	// DrainTimeout is how long in-flight restarts may continue after the run is cancelled
+	DrainTimeout time.Duration
+	// Rollback rolls workloads back to their previous revision instead of restarting them
+	Rollback bool
+	// AnnotateOnly only stamps the restartedAt annotation on controllers, standalone pods are
+	// skipped and nothing is waited for or deleted
+	AnnotateOnly bool
+	// ForceRecreate deletes controllers and creates them again from a snapshot, which requires
+	// BackupDir and ConfirmCluster
+	ForceRecreate bool
+	// ConfirmCluster asks for the context name to be typed before running against a cluster
+	ConfirmCluster bool
+	// EvictPods restarts controllers by evicting their pods instead of changing their template
+	EvictPods bool
+	// StopOnError starts no further restarts once one has failed
+	StopOnError bool
+	// TimestampFormat is the layout of the restartedAt annotation, or unix
+	TimestampFormat string
+	// RestartCount increments RestartCountAnnotation on every restarted pod template
+	RestartCount bool
+	// RunID makes retries of the same logical run skip resources it already restarted
+	RunID        string
+	FieldManager string
+	BackupDir    string
+
+	// Standalone pods are recreated under a new name, the original is removed once the new pod
+	// reaches one of AcceptPhases
+	AcceptPhases     map[v1.PodPhase]bool
+	UseEviction      bool
+	DeleteTimeout    time.Duration
+	TimeoutAction    string
+	ProbeEndpoints   bool
+	PostCreateExec   string
+	PostCreateWait   time.Duration
+	ClearAnnotations []string
+	DropLabels       []string
+	// VerifyImagePull compares the image IDs of the recreated pod with the original's
+	VerifyImagePull bool
+
+	// Waiting and verification
+	// WaitTimeout bounds each wait for a rollout or recreated pod. WatchTimeout bounds how long
+	// a rollout wait waits to establish, or hear from, a watch before falling back to polling;
+	// zero polls from the start. WaitShared waits for all restarted controllers at once under a
+	// single WaitTimeout instead
+	Wait         bool
+	WaitShared   bool
+	WaitTimeout  time.Duration
+	WatchTimeout time.Duration
+	Verify       bool
+
+	// Modes. Interval runs a pass every interval, Reactive restarts owners of crash looping pods as
+	// they are seen. List, Explain and Diff only preview.
+	Interval          time.Duration
+	Reactive          bool
+	ReactiveDebounce  time.Duration
+	CrashLoopRestarts int
+	List              bool
+	Explain           bool
+	Diff              bool
+	Preflight         bool
+
+	// Output is the summary format: text, wide or json
+	Output     string
+	ReportFile string
+	// ReportFormat is json for a single report document, or jsonl to stream a record per outcome
+	ReportFormat string
+	// ReportSkipped lists every matched pod that wasn't restarted with its reason in text output
+	ReportSkipped bool
+	Verbose       bool
+}
+
+// Validate fills in defaults for unset fields and reports invalid values and combinations
+func (o *Options) Validate() error {
+	if o.ReportFormat == "" {
+		o.ReportFormat = "json"
+	}
+	if o.Match == "" {
+		o.Match = DatabaseMatch
+	}
+	if o.Output == "" {
+		o.Output = "text"
+	}
+	if o.AcceptPhases == nil {
+		o.AcceptPhases = map[v1.PodPhase]bool{v1.PodRunning: true}
+	}
+	if o.NamespaceParallel == 0 {
+		o.NamespaceParallel = DefaultNamespaceParallel
+	}
+	if o.MatchExecParallel == 0 {
+		o.MatchExecParallel = DefaultMatchExecParallel
+	}
+	if o.HPAWindow == 0 {
+		o.HPAWindow = DefaultHPAWindow
+	}
+	if o.MatchExecTimeout == 0 {
+		o.MatchExecTimeout = DefaultMatchExecTimeout
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = 1
+	}
+	if o.TimeoutAction == "" {
+		o.TimeoutAction = TimeoutActionFail
+	}
+	if o.FieldManager == "" {
+		o.FieldManager = DefaultFieldManager
+	}
+	if o.TimestampFormat == "" {
+		o.TimestampFormat = time.RFC3339
+	}
+	if o.BatchWait || o.WaitShared {
+		o.Wait = true
+	}
+	if o.AnnotateOnly {
+		o.SkipBarePods = true
+	}
+	if o.WaitTimeout == 0 {
+		o.WaitTimeout = WaitForRestartTimeout
+	}
+
+	if o.Output != "text" && o.Output != "wide" && o.Output != "json" {
+		return fmt.Errorf("unsupported output format: %s", o.Output)
+	}
+	if o.ReportFormat != "json" && o.ReportFormat != "jsonl" {
+		return fmt.Errorf("unsupported report format: %s", o.ReportFormat)
+	}
+	if o.ReportFormat == "jsonl" && o.ReportFile == "" {
+		return fmt.Errorf("-report-format jsonl requires -report-file")
+	}
+	// fail fast on selector syntax errors rather than on the first list call
+	if _, err := labels.Parse(o.LabelSelector); err != nil {
+		return err
+	}
+	switch o.ConditionStatus {
+	case "", v1.ConditionTrue, v1.ConditionFalse, v1.ConditionUnknown:
+	default:
+		return fmt.Errorf("unsupported condition status, expected True, False or Unknown: %s", o.ConditionStatus)
+	}
+	if o.ConditionType != "" && o.ConditionStatus == "" {
+		return fmt.Errorf("-condition requires a status: %s", o.ConditionType)
+	}
+	if o.ConditionAge != 0 && o.ConditionType == "" {
+		return fmt.Errorf("-condition-age requires -condition")
+	}
+	if o.IncludeInitContainers && o.ContainerName == "" {
+		return fmt.Errorf("-include-init-containers requires -container-name")
+	}
+	if o.TenantLabel != "" {
+		if key, value, ok := strings.Cut(o.TenantLabel, "="); !ok || key == "" || value == "" {
+			return fmt.Errorf("expected key=value for -tenant-label: %q", o.TenantLabel)
+		}
+		if _, err := labels.Parse(o.TenantLabel); err != nil {
+			return err
+		}
+	}
+	if _, err := fields.ParseSelector(o.FieldSelector); err != nil {
+		return err
+	}
+	if _, err := labels.Parse(o.NamespaceSelector); err != nil {
+		return err
+	}
+	if o.VerifyImagePull && o.Rollback {
+		return fmt.Errorf("-verify-image-pull can't be combined with -rollback, standalone pods have no revisions")
+	}
+	switch o.PriorityOrder {
+	case "", PriorityOrderLowFirst, PriorityOrderHighFirst:
+	default:
+		return fmt.Errorf("unsupported priority order: %s", o.PriorityOrder)
+	}
+	switch o.TimeoutAction {
+	case TimeoutActionFail, TimeoutActionCleanup, TimeoutActionKeep, TimeoutActionDeleteOld:
+	default:
+		return fmt.Errorf("unsupported timeout action: %s", o.TimeoutAction)
+	}
+	if err := validateTimestampFormat(o.TimestampFormat); err != nil {
+		return err
+	}
+	for _, kind := range o.ExcludeKinds {
+		if kind != "Pod" && (kind == "" || getResourceType(kind) == "unsupported") {
+			return fmt.Errorf("unsupported kind in -exclude-kind: %q", kind)
+		}
+	}
+	for _, kind := range o.KindOrder {
+		if kind == "" || kind == "ReplicaSet" || getResourceType(kind) == "unsupported" {
+			return fmt.Errorf("unsupported kind in -parallel-kinds: %q", kind)
+		}
+	}
+	if o.Limit < 0 {
+		return fmt.Errorf("limit must not be negative: %d", o.Limit)
+	}
+	if o.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1: %d", o.Concurrency)
+	}
+	if o.NamespaceParallel < 1 {
+		return fmt.Errorf("namespace-parallel must be at least 1: %d", o.NamespaceParallel)
+	}
+	if o.MatchExecParallel < 1 {
+		return fmt.Errorf("match-exec-parallel must be at least 1: %d", o.MatchExecParallel)
+	}
+	if o.HPAWindow < 0 {
+		return fmt.Errorf("hpa-window must not be negative")
+	}
+	if o.MatchExecTimeout < 0 {
+		return fmt.Errorf("match-exec-timeout must not be negative")
+	}
+	if o.ThrottlePressuredNodes < 0 || o.ThrottlePendingPods < 0 {
+		return fmt.Errorf("throttle thresholds must not be negative")
+	}
+	if (o.ThrottlePressuredNodes > 0 || o.ThrottlePendingPods > 0) && o.ThrottleInterval <= 0 {
+		return fmt.Errorf("throttle-interval must be positive: %s", o.ThrottleInterval)
+	}
+	if o.BatchSize < 0 {
+		return fmt.Errorf("batch-size must not be negative: %d", o.BatchSize)
+	}
+	if (o.BatchDelay > 0 || o.BatchWait) && o.BatchSize == 0 {
+		return fmt.Errorf("-batch-delay and -batch-wait require -batch-size")
+	}
+	if o.WaitTimeout < 0 || o.WatchTimeout < 0 {
+		return fmt.Errorf("wait-timeout and watch-timeout must not be negative")
+	}
+
+	if o.WorkloadAnnotationKey != "" && !o.ByWorkload {
+		return fmt.Errorf("-workload-annotation requires -by-workload")
+	}
+	if o.Explain && o.ByWorkload {
+		return fmt.Errorf("-explain can't be combined with -by-workload")
+	}
+	if o.TargetKind != "" || o.TargetName != "" || o.TargetNamespace != "" {
+		if o.TargetKind == "" || o.TargetName == "" || o.TargetNamespace == "" {
+			return fmt.Errorf("-target-kind, -target-name and -target-namespace must be set together")
+		}
+		if o.TargetKind != "Pod" && getResourceType(o.TargetKind) == "unsupported" {
+			return fmt.Errorf("unsupported kind in -target-kind: %q", o.TargetKind)
+		}
+		if o.ByWorkload || o.Interval > 0 || o.Reactive {
+			return fmt.Errorf("-target-kind can't be combined with -by-workload, -interval or -watch-namespace-events")
+		}
+	}
+	if o.RequireHealthy && o.UnhealthyOnly {
+		return fmt.Errorf("-require-healthy-before can't be combined with -unhealthy-only")
+	}
+	if o.AnnotateOnly && (o.Wait || o.EvictPods || o.Rollback || o.TargetKind == "Pod") {
+		return fmt.Errorf("-annotate-only can't be combined with -wait, -batch-wait, -delete-pods, -rollback or -target-kind Pod")
+	}
+	if o.ForceRecreate {
+		if o.BackupDir == "" {
+			return fmt.Errorf("-force-recreate requires -backup-dir")
+		}
+		// recreating controllers is destructive enough to always make the operator confirm the cluster
+		if !o.ConfirmCluster {
+			return fmt.Errorf("-force-recreate requires -confirm-cluster")
+		}
+		if o.EvictPods || o.Rollback || o.AnnotateOnly {
+			return fmt.Errorf("-force-recreate can't be combined with -delete-pods, -rollback or -annotate-only")
+		}
+	}
+	if o.EvictPods && o.Rollback {
+		return fmt.Errorf("-delete-pods can't be combined with -rollback")
+	}
+	if o.Reactive && (o.Interval > 0 || o.ByWorkload || o.preview()) {
+		return fmt.Errorf("-watch-namespace-events can't be combined with -interval, -by-workload, -list, -explain or -dry-run-diff")
+	}
+	return nil
+}
+
+// targeted reports whether a single object was named with -target-kind and friends
+func (o Options) targeted() bool {
+	return o.TargetKind != ""
+}
+
+// preview reports whether the options only describe what would happen without restarting anything
+func (o Options) preview() bool {
+	return o.List || o.Explain || o.Diff
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Orders of -priority-order
+const (
+	// PriorityOrderLowFirst restarts the least important workloads first and critical ones last
+	PriorityOrderLowFirst = "low-first"
+	// PriorityOrderHighFirst restarts the most important workloads first
+	PriorityOrderHighFirst = "high-first"
+)
+
+// workItem is a resource to restart along with the matched pods that resolved to it
+type workItem struct {
+	resourceType string
+	kind         string
+	name         string
+	namespace    string
+	// pod is the first matched pod that resolved to the resource
+	pod  v1.Pod
+	pods []string
+}
+
+func (w workItem) key() string {
+	return resourceKey(w.kind, w.name, w.namespace)
+}
+
+// source names what led to the resource being restarted, the triggering pod or the workload itself
+func (w workItem) source() string {
+	if w.pod.Name != "" {
+		return w.pod.Name
+	}
+	return fmt.Sprintf("%s/%s", w.kind, w.name)
+}
+
+// planWorkItems resolves the selected pods to the resources that need restarting, collapsing pods
+// that share a higher level resource into a single work item
+func (c *kubeClient) planWorkItems(sum *summary, pods []v1.Pod) []workItem {
+	var items []workItem
+	index := make(map[string]int)
+
+	add := func(item workItem) {
+		// ensure we don't keep restarting the same higher level resource
+		if i, ok := index[item.key()]; ok {
+			c.infof("skipping already restarted resource: %s", item.key())
+			items[i].pods = append(items[i].pods, item.pod.Name)
+			sum.skip(item.pod, SkipReasonAlreadyRestarted)
+			return
+		}
+		index[item.key()] = len(items)
+		item.pods = []string{item.pod.Name}
+		items = append(items, item)
+	}
+
+	for _, pod := range pods {
+		// retrieve owner references to identify supported restart resources
+		if len(pod.OwnerReferences) == 0 {
+			// recreating a standalone pod just replaces one unmanaged pod with another
+			if c.opts.SkipBarePods {
+				c.infof("skipping pod without an owner: %s, consider managing it with a Deployment", pod.Name)
+				sum.skip(pod, SkipReasonBarePod)
+				continue
+			}
+			c.warnf("warning: pod: %s has no owner and will be recreated as another standalone pod, consider managing it with a Deployment", pod.Name)
+			add(workItem{resourceType: "Pod", kind: "Pod", name: pod.Name, namespace: pod.Namespace, pod: pod})
+			continue
+		}
+
+		if c.opts.StrictOwner && !hasSingleSupportedOwner(pod) {
+			c.infof("skipping pod: %s with owners that don't resolve to exactly one supported controller", pod.Name)
+			sum.skip(pod, SkipReasonAmbiguousOwner)
+			continue
+		}
+
+		ownerRefs := pod.OwnerReferences
+		if len(c.opts.OwnerKindPriority) > 0 && len(ownerRefs) > 1 {
+			ownerRefs = []metav1.OwnerReference{preferredOwner(ownerRefs, c.opts.OwnerKindPriority)}
+		}
+
+		for _, ownerRef := range ownerRefs {
+			resourceType := getOwnerResourceType(ownerRef)
+			if resourceType == "unsupported" {
+				c.infof("skipping restart unknown resource type for pod: %s", pod.Name)
+				sum.skip(pod, SkipReasonUnsupported)
+				continue
+			}
+			if resourceType == "Pod" {
+				continue
+			}
+			add(workItem{resourceType: resourceType, kind: ownerRef.Kind, name: ownerRef.Name, namespace: pod.Namespace, pod: pod})
+		}
+	}
+
+	return items
+}
+
+// preferredOwner picks the owner whose kind comes first in the priority list, owners of kinds not
+// listed come after in their original order
+func preferredOwner(ownerRefs []metav1.OwnerReference, priority []string) metav1.OwnerReference {
+	rank := func(kind string) int {
+		if i := slices.Index(priority, kind); i >= 0 {
+			return i
+		}
+		return len(priority)
+	}
+
+	preferred := ownerRefs[0]
+	for _, ownerRef := range ownerRefs[1:] {
+		if rank(ownerRef.Kind) < rank(preferred.Kind) {
+			preferred = ownerRef
+		}
+	}
+	return preferred
+}
+
+// hasSingleSupportedOwner reports whether every owner of the pod is of a supported kind and
+// exactly one of them is its controller
+func hasSingleSupportedOwner(pod v1.Pod) bool {
+	controllers := 0
+	for _, ownerRef := range pod.OwnerReferences {
+		resourceType := getOwnerResourceType(ownerRef)
+		if resourceType == "unsupported" || resourceType == "Pod" {
+			return false
+		}
+		if ownerRef.Controller != nil && *ownerRef.Controller {
+			controllers++
+		}
+	}
+	return controllers == 1
+}
+
+// sampleWorkItems applies -sample and -limit to the planned work items. Items are ordered by name
+// first so the same cluster state always selects the same resources.
+func (c *kubeClient) sampleWorkItems(sum *summary, items []workItem) []workItem {
+	if c.opts.SampleDen == 0 && c.opts.Limit == 0 {
+		return items
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].name != items[j].name {
+			return items[i].name < items[j].name
+		}
+		if items[i].namespace != items[j].namespace {
+			return items[i].namespace < items[j].namespace
+		}
+		return items[i].kind < items[j].kind
+	})
+
+	var selected []workItem
+	for i, item := range items {
+		if c.opts.SampleDen > 0 && i%c.opts.SampleDen >= c.opts.SampleNum {
+			sum.skipItem(item, SkipReasonNotSampled)
+			continue
+		}
+		if c.opts.Limit > 0 && len(selected) >= c.opts.Limit {
+			sum.skipItem(item, SkipReasonLimit)
+			continue
+		}
+		selected = append(selected, item)
+	}
+	return selected
+}
+
+// orderByPriority orders the work items by the scheduling priority of their triggering pod for
+// -priority-order. Pods without a resolved priority, and work items without a triggering pod, rank
+// as priority 0. Items of equal priority keep their order, and -namespace-delay and -parallel-kinds
+// still group the items, the order applies within each group.
+func (c *kubeClient) orderByPriority(items []workItem) {
+	if c.opts.PriorityOrder == "" {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		pi, pj := podPriority(items[i].pod), podPriority(items[j].pod)
+		if c.opts.PriorityOrder == PriorityOrderHighFirst {
+			return pi > pj
+		}
+		return pi < pj
+	})
+}
+
+// podPriority is the priority admission resolved from the pod's PriorityClassName
+func podPriority(pod v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// parseSample parses a fraction such as 1/3
+func parseSample(sample string) (int, int, error) {
+	num, den, ok := strings.Cut(sample, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a fraction such as 1/3: %q", sample)
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid sample numerator: %w", err)
+	}
+	d, err := strconv.Atoi(den)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid sample denominator: %w", err)
+	}
+	if n < 1 || d < n {
+		return 0, 0, fmt.Errorf("sample must be a fraction between 0 and 1: %q", sample)
+	}
+	return n, d, nil
+}
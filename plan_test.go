@@ -0,0 +1,127 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"testing"
+)
+
+func TestPlanWorkItemsKeys(t *testing.T) {
+	ref := func(kind, name string) *metav1.OwnerReference {
+		owner := controllerRef(kind, name)
+		return &owner
+	}
+	pods := []v1.Pod{
+		testPod("database-0", nil),
+		testPod("database-123-abcde", ref("Deployment", "database")),
+		testPod("database-123-fghij", ref("Deployment", "database")),
+		testPod("database-1", ref("StatefulSet", "database")),
+	}
+
+	c, _ := newTestClient(Options{})
+	sum := c.newSummary()
+	items := c.planWorkItems(sum, pods)
+
+	var got []string
+	for _, item := range items {
+		got = append(got, item.key())
+	}
+	want := []string{"Pod|database-0|default", "Deployment|database|default", "StatefulSet|database|default"}
+	if !slices.Equal(got, want) {
+		t.Errorf("planWorkItems() keys = %v, want %v", got, want)
+	}
+	if pods := items[1].pods; !slices.Equal(pods, []string{"database-123-abcde", "database-123-fghij"}) {
+		t.Errorf("deployment work item pods = %v, want both of its pods", pods)
+	}
+}
+
+func TestPlanWorkItemsOwnerKindPriority(t *testing.T) {
+	custom := controllerRef("Database", "database")
+	custom.APIVersion = "example.com/v1"
+	replicaSet := controllerRef("ReplicaSet", "database-123")
+	replicaSet.Controller = nil
+	statefulSet := controllerRef("StatefulSet", "database")
+	statefulSet.Controller = nil
+
+	tests := []struct {
+		name     string
+		owners   []metav1.OwnerReference
+		priority []string
+		want     []string
+	}{
+		{
+			name:   "every owner without a priority",
+			owners: []metav1.OwnerReference{replicaSet, statefulSet},
+			want:   []string{"ReplicaSet|database-123|default", "StatefulSet|database|default"},
+		},
+		{
+			name:     "preferred kind",
+			owners:   []metav1.OwnerReference{replicaSet, statefulSet},
+			priority: []string{"StatefulSet", "ReplicaSet"},
+			want:     []string{"StatefulSet|database|default"},
+		},
+		{
+			name:     "custom controller listed first",
+			owners:   []metav1.OwnerReference{custom, replicaSet},
+			priority: []string{"ReplicaSet"},
+			want:     []string{"ReplicaSet|database-123|default"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestClient(Options{OwnerKindPriority: tt.priority})
+			pod := testPod("database-0", nil)
+			pod.OwnerReferences = tt.owners
+
+			items := c.planWorkItems(c.newSummary(), []v1.Pod{pod})
+			var got []string
+			for _, item := range items {
+				got = append(got, item.key())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("planWorkItems() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderByPriority(t *testing.T) {
+	item := func(name string, priority *int32) workItem {
+		pod := testPod(name+"-0", nil)
+		pod.Spec.Priority = priority
+		return workItem{resourceType: "StatefulSet", kind: "StatefulSet", name: name, namespace: "default", pod: pod}
+	}
+	priority := func(value int32) *int32 { return &value }
+	items := []workItem{
+		item("critical", priority(1000)),
+		item("unset", nil),
+		item("batch", priority(-10)),
+		item("default", priority(0)),
+	}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{order: "", want: []string{"critical", "unset", "batch", "default"}},
+		{order: PriorityOrderLowFirst, want: []string{"batch", "unset", "default", "critical"}},
+		{order: PriorityOrderHighFirst, want: []string{"critical", "unset", "default", "batch"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			c, _ := newTestClient(Options{PriorityOrder: tt.order})
+			ordered := slices.Clone(items)
+			c.orderByPriority(ordered)
+			var got []string
+			for _, item := range ordered {
+				got = append(got, item.name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("orderByPriority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"strings"
+	"time"
+)
+
+// postCreate runs the -post-create-exec command in the recreated pod and then waits
+// -post-create-wait, giving the new pod a chance to take over, for example by promoting a replica,
+// before the original is removed. Both are bounded by -wait-timeout.
+func (c *kubeClient) postCreate(ctx context.Context, instance *v1.Pod) error {
+	if c.opts.PostCreateExec == "" && c.opts.PostCreateWait == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.WaitTimeout)
+	defer cancel()
+
+	if c.opts.PostCreateExec != "" {
+		c.infof("running post create command in pod: %s in namespace: %s", instance.Name, instance.Namespace)
+		if err := c.execInPod(ctx, instance, []string{"sh", "-c", c.opts.PostCreateExec}); err != nil {
+			return fmt.Errorf("post create command in pod %s/%s failed, kept %s and the original: %w", instance.Namespace, instance.Name, instance.Name, err)
+		}
+	}
+
+	if c.opts.PostCreateWait > 0 {
+		c.infof("waiting %s after creating pod: %s in namespace: %s", c.opts.PostCreateWait, instance.Name, instance.Namespace)
+		timer := time.NewTimer(c.opts.PostCreateWait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("interrupted waiting after creating pod %s/%s: %w", instance.Namespace, instance.Name, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// execInPod runs a command through the exec subresource in the -container-name container, or the
+// first container of the pod
+func (c *kubeClient) execInPod(ctx context.Context, pod *v1.Pod, command []string) error {
+	if c.config == nil {
+		return fmt.Errorf("exec needs a client configuration")
+	}
+
+	container := pod.Spec.Containers[0].Name
+	if c.opts.ContainerName != "" {
+		container = c.opts.ContainerName
+	}
+	req := c.clientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(c.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	if out := strings.TrimSpace(stdout.String()); out != "" {
+		c.infof("%s", out)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
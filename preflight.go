@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+type permission struct {
+	verb        string
+	group       string
+	resource    string
+	subresource string
+}
+
+func (p permission) String() string {
+	resource := p.resource
+	if p.subresource != "" {
+		resource = fmt.Sprintf("%s/%s", resource, p.subresource)
+	}
+	if p.group != "" {
+		resource = fmt.Sprintf("%s.%s", resource, p.group)
+	}
+	return fmt.Sprintf("%s %s", p.verb, resource)
+}
+
+// requiredPermissions returns every permission the run may need given the current options
+func (c *kubeClient) requiredPermissions() []permission {
+	perms := []permission{
+		{verb: "list", resource: "pods"},
+		{verb: "get", resource: "pods"},
+		{verb: "create", resource: "pods"},
+		{verb: "delete", resource: "pods"},
+		{verb: "list", resource: "events"},
+		{verb: "get", group: "apps", resource: "replicasets"},
+		{verb: "get", group: "apps", resource: "deployments"},
+		{verb: "update", group: "apps", resource: "deployments"},
+		{verb: "get", group: "apps", resource: "statefulsets"},
+		{verb: "update", group: "apps", resource: "statefulsets"},
+		{verb: "get", group: "apps", resource: "daemonsets"},
+		{verb: "update", group: "apps", resource: "daemonsets"},
+	}
+	if c.opts.ByWorkload {
+		perms = append(perms,
+			permission{verb: "list", group: "apps", resource: "deployments"},
+			permission{verb: "list", group: "apps", resource: "statefulsets"},
+			permission{verb: "list", group: "apps", resource: "daemonsets"},
+		)
+	}
+	if c.opts.Interval > 0 || c.opts.Reactive {
+		perms = append(perms, permission{verb: "watch", resource: "pods"})
+	}
+	if c.opts.Wait && c.opts.WatchTimeout > 0 {
+		perms = append(perms,
+			permission{verb: "watch", group: "apps", resource: "deployments"},
+			permission{verb: "watch", group: "apps", resource: "statefulsets"},
+			permission{verb: "watch", group: "apps", resource: "daemonsets"},
+		)
+		// statefulset ordinals are followed through their pods
+		if c.opts.Interval == 0 && !c.opts.Reactive {
+			perms = append(perms, permission{verb: "watch", resource: "pods"})
+		}
+	}
+	if c.opts.ForceRecreate {
+		for _, resource := range []string{"replicasets", "deployments", "statefulsets", "daemonsets"} {
+			perms = append(perms,
+				permission{verb: "delete", group: "apps", resource: resource},
+				permission{verb: "create", group: "apps", resource: resource},
+			)
+		}
+	}
+	if c.opts.RespectHPA {
+		perms = append(perms, permission{verb: "list", group: "autoscaling", resource: "horizontalpodautoscalers"})
+	}
+	if c.opts.ThrottlePressuredNodes > 0 {
+		perms = append(perms, permission{verb: "list", resource: "nodes"})
+	}
+	if c.opts.NamespaceSelector != "" {
+		perms = append(perms, permission{verb: "list", resource: "namespaces"})
+	}
+	if c.opts.ConfigMapName != "" {
+		perms = append(perms, permission{verb: "get", resource: "configmaps"})
+	}
+	if c.opts.UseEviction || c.opts.EvictPods {
+		perms = append(perms, permission{verb: "create", resource: "pods", subresource: "eviction"})
+	}
+	if c.opts.Rollback {
+		perms = append(perms,
+			permission{verb: "list", group: "apps", resource: "replicasets"},
+			permission{verb: "list", group: "apps", resource: "controllerrevisions"},
+			permission{verb: "patch", group: "apps", resource: "statefulsets"},
+			permission{verb: "patch", group: "apps", resource: "daemonsets"},
+		)
+	}
+	if c.opts.Diff {
+		perms = append(perms,
+			permission{verb: "patch", group: "apps", resource: "deployments"},
+			permission{verb: "patch", group: "apps", resource: "statefulsets"},
+			permission{verb: "patch", group: "apps", resource: "daemonsets"},
+		)
+	}
+	if c.opts.PostCreateExec != "" {
+		perms = append(perms, permission{verb: "create", resource: "pods", subresource: "exec"})
+	}
+	if c.opts.ProbeEndpoints {
+		perms = append(perms,
+			permission{verb: "list", resource: "services"},
+			permission{verb: "list", group: "discovery.k8s.io", resource: "endpointslices"},
+		)
+	}
+	if c.opts.SkipCordonedNodes || c.opts.Zone != "" {
+		perms = append(perms, permission{verb: "get", resource: "nodes"})
+	}
+	return perms
+}
+
+// clusterScoped reports whether the resource isn't namespaced, so it is checked cluster wide
+func (p permission) clusterScoped() bool {
+	return p.resource == "nodes" || p.resource == "namespaces"
+}
+
+// preflight confirms the current identity holds every permission the run needs in the target
+// namespaces, so a forbidden error doesn't abort the run after some resources were restarted.
+// Namespaced permissions are checked in every target namespace, which lets an identity with only
+// namespace scoped RBAC pass, and cluster wide when the run isn't scoped to namespaces.
+func (c *kubeClient) preflight(ctx context.Context) error {
+	namespaces := []string{metav1.NamespaceAll}
+	switch {
+	case c.opts.targeted():
+		namespaces = []string{c.opts.TargetNamespace}
+	case c.scopedToNamespaces():
+		var err error
+		if namespaces, err = c.targetNamespaces(ctx); err != nil {
+			return err
+		}
+	}
+
+	var missing []string
+	for _, perm := range c.requiredPermissions() {
+		scopes := namespaces
+		if perm.clusterScoped() {
+			scopes = []string{metav1.NamespaceAll}
+		}
+		for _, namespace := range scopes {
+			allowed, err := c.accessAllowed(ctx, perm, namespace)
+			if err != nil {
+				return err
+			}
+			if allowed {
+				continue
+			}
+			if namespace == metav1.NamespaceAll {
+				missing = append(missing, perm.String())
+			} else {
+				missing = append(missing, fmt.Sprintf("%s in namespace %s", perm, namespace))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("preflight failed, missing permissions:\n  %s", strings.Join(missing, "\n  "))
+	}
+	return nil
+}
+
+// accessAllowed asks the API server whether the current identity holds the permission in the
+// namespace, or cluster wide for an empty namespace
+func (c *kubeClient) accessAllowed(ctx context.Context, perm permission, namespace string) (bool, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        perm.verb,
+				Group:       perm.group,
+				Resource:    perm.resource,
+				Subresource: perm.subresource,
+			},
+		},
+	}
+
+	result, err := c.clientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("checking permission to %s: %w", perm, err)
+	}
+	return result.Status.Allowed, nil
+}
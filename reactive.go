@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"time"
+)
+
+// runReactive watches pods and restarts the owner of any matching pod that keeps crashing, until
+// the context is cancelled. Each owner is restarted at most once per debounce window so a single
+// bad pod doesn't trigger a restart on every status update.
+func (c *kubeClient) runReactive(ctx context.Context) (Result, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.clientSet, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = c.labelSelector()
+			options.FieldSelector = c.opts.FieldSelector
+		}))
+	podInformer := factory.Core().V1().Pods()
+	podLister := podInformer.Lister()
+
+	queue := workqueue.NewTyped[string]()
+	defer queue.ShutDown()
+
+	enqueue := func(obj interface{}) {
+		pod, ok := obj.(*v1.Pod)
+		if !ok || !c.isCrashLooping(pod) {
+			return
+		}
+		key, err := cache.MetaNamespaceKeyFunc(pod)
+		if err == nil {
+			queue.Add(key)
+		}
+	}
+	if _, err := podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	}); err != nil {
+		return Result{}, err
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return Result{}, fmt.Errorf("timed out waiting for %v cache to sync", informerType)
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+
+	var last Result
+	restartedAt := make(map[string]time.Time)
+	for {
+		key, shutdown := queue.Get()
+		if shutdown {
+			return last, nil
+		}
+
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err == nil {
+			var pod *v1.Pod
+			pod, err = podLister.Pods(namespace).Get(name)
+			if err == nil {
+				last = c.restartCrashLoopingOwner(ctx, *pod.DeepCopy(), restartedAt)
+			}
+		}
+		if err != nil {
+			c.infof("skipping pod: %s: %s", key, err)
+		}
+		queue.Done(key)
+	}
+}
+
+// restartCrashLoopingOwner restarts the top level owner of a crash looping pod, unless it was
+// already restarted within the debounce window
+func (c *kubeClient) restartCrashLoopingOwner(ctx context.Context, pod v1.Pod, restartedAt map[string]time.Time) Result {
+	// pods of the same top level owner share a single debounce window, a Deployment restart
+	// replaces the ReplicaSet so its new pods must not count as a different owner
+	kind, name, err := c.topOwner(ctx, pod)
+	if err != nil {
+		c.infof("skipping pod: %s: %s", pod.Name, err)
+		return Result{}
+	}
+	if name == "" {
+		name = pod.Name
+	}
+	owner := resourceKey(kind, name, pod.Namespace)
+	if at, ok := restartedAt[owner]; ok && time.Since(at) < c.opts.ReactiveDebounce {
+		return Result{}
+	}
+
+	if c.scopedToNamespaces() {
+		namespaces, err := c.targetNamespaces(ctx)
+		if err != nil {
+			c.infof("skipping pod: %s: %s", pod.Name, err)
+			return Result{}
+		}
+		if len(inNamespaces([]v1.Pod{pod}, namespaces)) == 0 {
+			return Result{}
+		}
+	}
+
+	c.infof("pod: %s in namespace: %s is crash looping, restarting its owner", pod.Name, pod.Namespace)
+	result := c.restartPods(ctx, []v1.Pod{pod})
+	if result.Matched > 0 {
+		restartedAt[owner] = time.Now()
+		c.printSummary(result)
+	}
+	return result
+}
+
+// isCrashLooping reports whether a container of the pod is in CrashLoopBackOff after at least
+// -crashloop-restarts restarts
+func (c *kubeClient) isCrashLooping(pod *v1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" && int(status.RestartCount) >= c.opts.CrashLoopRestarts {
+			return true
+		}
+	}
+	return false
+}
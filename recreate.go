@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"maps"
+	"time"
+)
+
+// forceRecreate deletes the controller and creates it again from a snapshot with fresh metadata,
+// for changes a rollout can't make such as to immutable fields. The controller is deleted with
+// orphan propagation so its pods keep serving until the recreated controller replaces them.
+func (c *kubeClient) forceRecreate(ctx context.Context, resourceType, name, namespace string) ([]string, error) {
+	switch resourceType {
+	case "ReplicaSet":
+		client := c.clientSet.AppsV1().ReplicaSets(namespace)
+		rs, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		if owner := metav1.GetControllerOf(rs); owner != nil && getOwnerResourceType(*owner) == "Deployment" {
+			return c.forceRecreate(ctx, "Deployment", owner.Name, namespace)
+		}
+		recreated := &appsv1.ReplicaSet{ObjectMeta: c.recreatedMeta(rs.ObjectMeta), Spec: *rs.Spec.DeepCopy()}
+		return recreateController(ctx, c, resourceType, client, rs, rs.ObjectMeta, rs.Spec.Replicas, rs.Status.ReadyReplicas, replicaCount(rs.Spec.Replicas), recreated, &recreated.Spec.Template)
+	case "Deployment":
+		client := c.clientSet.AppsV1().Deployments(namespace)
+		deploy, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		recreated := &appsv1.Deployment{ObjectMeta: c.recreatedMeta(deploy.ObjectMeta), Spec: *deploy.Spec.DeepCopy()}
+		return recreateController(ctx, c, resourceType, client, deploy, deploy.ObjectMeta, deploy.Spec.Replicas, deploy.Status.ReadyReplicas, replicaCount(deploy.Spec.Replicas), recreated, &recreated.Spec.Template)
+	case "StatefulSet":
+		client := c.clientSet.AppsV1().StatefulSets(namespace)
+		sts, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		recreated := &appsv1.StatefulSet{ObjectMeta: c.recreatedMeta(sts.ObjectMeta), Spec: *sts.Spec.DeepCopy()}
+		return recreateController(ctx, c, resourceType, client, sts, sts.ObjectMeta, sts.Spec.Replicas, sts.Status.ReadyReplicas, replicaCount(sts.Spec.Replicas), recreated, &recreated.Spec.Template)
+	case "DaemonSet":
+		client := c.clientSet.AppsV1().DaemonSets(namespace)
+		ds, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		// daemonsets have no replica count, one pod runs on every eligible node
+		recreated := &appsv1.DaemonSet{ObjectMeta: c.recreatedMeta(ds.ObjectMeta), Spec: *ds.Spec.DeepCopy()}
+		return recreateController(ctx, c, resourceType, client, ds, ds.ObjectMeta, nil, ds.Status.NumberReady, ds.Status.DesiredNumberScheduled, recreated, &recreated.Spec.Template)
+	}
+
+	return nil, nil
+}
+
+// recreateClient is the part of a typed apps/v1 client forceRecreate uses
+type recreateClient[T runtime.Object] interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (T, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	Create(ctx context.Context, obj T, opts metav1.CreateOptions) (T, error)
+}
+
+// recreatedMeta is the metadata of the controller replacing the deleted one
+func (c *kubeClient) recreatedMeta(meta metav1.ObjectMeta) metav1.ObjectMeta {
+	fresh := freshObjectMeta(meta)
+	c.stampRestart(&fresh)
+	return fresh
+}
+
+// recreateController applies the usual restart guards, takes the mandatory backup of the
+// controller, deletes it, waits for it to be gone and creates the replacement. Once the delete
+// went through the replacement is created even when the run is cancelled, a failure after the
+// delete leaves only the backup, so the error says where to find it. Replicas is nil for kinds
+// without a replica count, ready and desired are the controller's ready and desired pods.
+func recreateController[T runtime.Object](ctx context.Context, c *kubeClient, kind string, client recreateClient[T], original T, meta metav1.ObjectMeta, replicas *int32, ready, desired int32, recreated T, template *v1.PodTemplateSpec) ([]string, error) {
+	if err := c.restartGuard(meta); err != nil {
+		return nil, err
+	}
+	if replicas != nil {
+		if err := c.replicaGuard(kind, meta, replicas); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.healthGuard(ready, desired); err != nil {
+		return nil, err
+	}
+	if err := c.hpaGuard(ctx, kind, meta); err != nil {
+		return nil, err
+	}
+	backup, err := c.backupFile(original)
+	if err != nil {
+		return nil, err
+	}
+	c.stampTemplate(template)
+
+	c.warnf("force recreating %s: %s in namespace: %s, a backup was written to: %s", kind, meta.Name, meta.Namespace, backup)
+	orphan := metav1.DeletePropagationOrphan
+	if err := client.Delete(ctx, meta.Name, metav1.DeleteOptions{PropagationPolicy: &orphan}); err != nil {
+		return nil, ownerGone(err, kind, meta.Name)
+	}
+
+	// the controller is gone, don't let a cancelled run leave it that way
+	recreateCtx := context.WithoutCancel(ctx)
+	err = wait.PollUntilContextTimeout(recreateCtx, ConfigRestartInterval*time.Second, c.opts.WaitTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := client.Get(ctx, meta.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err == nil {
+		createCtx, cancel := context.WithTimeout(recreateCtx, CleanupTimeout)
+		_, err = client.Create(createCtx, recreated, metav1.CreateOptions{FieldManager: c.opts.FieldManager})
+		cancel()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recreating %s %s/%s, restore it from the backup %s: %w", kind, meta.Namespace, meta.Name, backup, err)
+	}
+	return containerImages(template.Spec), nil
+}
+
+// freshObjectMeta keeps the identity, labels, annotations and owners of an object and drops
+// everything the API server assigned to the deleted instance
+func freshObjectMeta(meta metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            meta.Name,
+		Namespace:       meta.Namespace,
+		Labels:          maps.Clone(meta.Labels),
+		Annotations:     maps.Clone(meta.Annotations),
+		OwnerReferences: meta.OwnerReferences,
+	}
+}
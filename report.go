@@ -0,0 +1,627 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	SkipReasonAlreadyRestarted   = "already restarted"
+	SkipReasonCordoned           = "node cordoned"
+	SkipReasonUnsupported        = "unsupported owner kind"
+	SkipReasonConfigNotUsed      = "configmap not used"
+	SkipReasonConfigUnchanged    = "configmap unchanged"
+	SkipReasonOwnerUID           = "owner uid mismatch"
+	SkipReasonNotSampled         = "not sampled"
+	SkipReasonLimit              = "over limit"
+	SkipReasonOtherNode          = "not on node"
+	SkipReasonRunCompleted       = "already restarted by run"
+	SkipReasonBarePod            = "no owner"
+	SkipReasonNotAttempted       = "not attempted"
+	SkipReasonMinReplicas        = "below min-replicas"
+	SkipReasonNoPreviousRevision = "no previous revision"
+	SkipReasonPVCNotMounted      = "pvc not mounted"
+	SkipReasonAmbiguousOwner     = "ambiguous owner"
+	SkipReasonImageUnchanged     = "image unchanged"
+	SkipReasonExcludedOwner      = "excluded owner"
+	SkipReasonExcludedKind       = "excluded kind"
+	SkipReasonOtherZone          = "not in zone"
+	SkipReasonUnhealthy          = "unhealthy before restart"
+	SkipReasonHealthy            = "healthy"
+	SkipReasonOwnerGone          = "owner no longer exists"
+	SkipReasonCondition          = "condition not met"
+	SkipReasonExecNoMatch        = "rejected by match-exec"
+	SkipReasonScaling            = "autoscaler scaling"
+)
+
+// ResultStatus is the machine readable outcome of every restarted resource, skipped pod and failure
+// in the report, so automation can branch on it instead of parsing reasons and error messages
+type ResultStatus string
+
+const (
+	StatusRestarted               ResultStatus = "Restarted"
+	StatusSkippedAlreadyRestarted ResultStatus = "SkippedAlreadyRestarted"
+	StatusSkippedUnsupported      ResultStatus = "SkippedUnsupported"
+	StatusSkippedFiltered         ResultStatus = "SkippedFiltered"
+	StatusFailed                  ResultStatus = "Failed"
+	StatusTimedOut                ResultStatus = "TimedOut"
+	// StatusDryRun marks a resource -list, -explain or -dry-run-diff would have restarted
+	StatusDryRun ResultStatus = "DryRun"
+)
+
+// skipStatus classifies a skip reason, every reason not about duplicates or unsupported owners
+// is a filter or guard that deliberately left the pod alone
+func skipStatus(reason string) ResultStatus {
+	switch reason {
+	case SkipReasonAlreadyRestarted, SkipReasonRunCompleted:
+		return StatusSkippedAlreadyRestarted
+	case SkipReasonUnsupported, SkipReasonAmbiguousOwner:
+		return StatusSkippedUnsupported
+	}
+	return StatusSkippedFiltered
+}
+
+// errorStatus tells failures that ran out of time apart from the others
+func errorStatus(err error) ResultStatus {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errRestartTimeout) {
+		return StatusTimedOut
+	}
+	return StatusFailed
+}
+
+// webhookDeniedPattern matches the message the API server returns when an admission webhook
+// denies a request
+var webhookDeniedPattern = regexp.MustCompile(`admission webhook "([^"]+)" denied the request:?\s*(.*)`)
+
+// restartedResource records a resource restarted during a pass
+type restartedResource struct {
+	Kind      string       `json:"kind"`
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace"`
+	Images    []string     `json:"images"`
+	Status    ResultStatus `json:"status"`
+	// ImagePulls compares the images of a recreated standalone pod with -verify-image-pull
+	ImagePulls []imagePull `json:"imagePulls,omitempty"`
+	// Pod, Node and Created describe the matched pod that led to the restart
+	Pod     string    `json:"pod,omitempty"`
+	Node    string    `json:"node,omitempty"`
+	Created time.Time `json:"-"`
+}
+
+func (r restartedResource) key() string {
+	return resourceKey(r.Kind, r.Name, r.Namespace)
+}
+
+// skippedPod records a matched pod that was deliberately not restarted
+type skippedPod struct {
+	Pod       string       `json:"pod"`
+	Namespace string       `json:"namespace"`
+	Reason    string       `json:"reason"`
+	Status    ResultStatus `json:"status"`
+}
+
+// summary tallies the outcome of a single restart pass
+type summary struct {
+	// mu guards the summary while work items are restarted concurrently
+	mu        sync.Mutex
+	matched   int
+	targeted  map[string]bool
+	restarted []restartedResource
+	skipped   []skippedPod
+	allErrs   []podError
+	// report streams every outcome as it is recorded with -report-format jsonl
+	report  *reportStream
+	cluster string
+	log     *slog.Logger
+}
+
+func (c *kubeClient) newSummary() *summary {
+	return &summary{targeted: make(map[string]bool), report: c.report, cluster: c.cluster, log: c.log}
+}
+
+// stream writes an outcome to the jsonl report, callers hold the lock
+func (s *summary) stream(recordType string, record any) {
+	if err := s.report.write(recordType, s.cluster, record); err != nil {
+		s.log.Warn(fmt.Sprintf("failed writing report record: %s", err))
+	}
+}
+
+func (s *summary) skip(pod v1.Pod, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped = append(s.skipped, skippedPod{pod.Name, pod.Namespace, reason, skipStatus(reason)})
+	s.stream("skipped", s.skipped[len(s.skipped)-1])
+}
+
+func (s *summary) skipItem(item workItem, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped = append(s.skipped, skippedPod{item.source(), item.namespace, reason, skipStatus(reason)})
+	s.stream("skipped", s.skipped[len(s.skipped)-1])
+}
+
+func (s *summary) target(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targeted[key] = true
+}
+
+func (s *summary) recordRestart(r restartedResource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restarted = append(s.restarted, r)
+	s.stream("restarted", r)
+}
+
+// recordPreview records a resource a preview would have restarted, nothing was changed
+func (s *summary) recordPreview(r restartedResource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Status = StatusDryRun
+	s.restarted = append(s.restarted, r)
+	s.stream("dryRun", r)
+}
+
+func (s *summary) fail(name, namespace string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allErrs = append(s.allErrs, podError{name, namespace, err})
+	s.stream("failed", newSummaryError(s.allErrs[len(s.allErrs)-1]))
+}
+
+func (s *summary) failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.allErrs) > 0
+}
+
+// restartedSince returns the resources restarted after the first n
+func (s *summary) restartedSince(n int) []restartedResource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.restarted[n:])
+}
+
+// counts returns how many resources were restarted and how many failures were recorded so far
+func (s *summary) counts() (restarted, failed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.restarted), len(s.allErrs)
+}
+
+// Result is the outcome of a restart pass, returned by run so callers decide how to present it
+type Result struct {
+	Matched   int
+	Targeted  int
+	Restarted []restartedResource
+	Skipped   []skippedPod
+	Errors    []podError
+}
+
+// result snapshots the summary. Restarted and skipped resources are sorted so the output doesn't
+// depend on the order pods were listed or restarts finished in.
+func (s *summary) result() Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restarted := slices.Clone(s.restarted)
+	sort.SliceStable(restarted, func(i, j int) bool {
+		if restarted[i].Kind != restarted[j].Kind {
+			return restarted[i].Kind < restarted[j].Kind
+		}
+		if restarted[i].Namespace != restarted[j].Namespace {
+			return restarted[i].Namespace < restarted[j].Namespace
+		}
+		return restarted[i].Name < restarted[j].Name
+	})
+	skipped := slices.Clone(s.skipped)
+	sort.SliceStable(skipped, func(i, j int) bool {
+		if skipped[i].Namespace != skipped[j].Namespace {
+			return skipped[i].Namespace < skipped[j].Namespace
+		}
+		return skipped[i].Pod < skipped[j].Pod
+	})
+
+	return Result{
+		Matched:   s.matched,
+		Targeted:  len(s.targeted),
+		Restarted: restarted,
+		Skipped:   skipped,
+		Errors:    slices.Clone(s.allErrs),
+	}
+}
+
+// skipReasons counts skipped pods by reason
+func (r Result) skipReasons() map[string]int {
+	reasons := make(map[string]int)
+	for _, skipped := range r.Skipped {
+		reasons[skipped.Reason]++
+	}
+	return reasons
+}
+
+type summaryError struct {
+	Pod       string       `json:"pod"`
+	Namespace string       `json:"namespace,omitempty"`
+	Error     string       `json:"error"`
+	Status    ResultStatus `json:"status"`
+	// Webhook names the admission webhook when the failure is a policy rejection
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// errorGroup collects the failures sharing a cause
+type errorGroup struct {
+	cause      string
+	count      int
+	namespaces []string
+}
+
+// groupErrors collapses failures with the same cause, in order of first occurrence. API errors are
+// grouped by their status reason since their messages name the individual resource.
+func groupErrors(errs []podError) []errorGroup {
+	var groups []errorGroup
+	index := make(map[string]int)
+	for _, e := range errs {
+		cause := errorCause(e.restartError)
+		i, ok := index[cause]
+		if !ok {
+			i = len(groups)
+			index[cause] = i
+			groups = append(groups, errorGroup{cause: cause})
+		}
+		groups[i].count++
+		if e.namespace != "" && !slices.Contains(groups[i].namespaces, e.namespace) {
+			groups[i].namespaces = append(groups[i].namespaces, e.namespace)
+		}
+	}
+	return groups
+}
+
+func errorCause(err error) string {
+	if webhook, message, ok := webhookDenial(err); ok {
+		return fmt.Sprintf("policy rejection by webhook %s: %s", webhook, message)
+	}
+	if reason := apierrors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return strings.ToLower(string(reason))
+	}
+	for errors.Unwrap(err) != nil {
+		err = errors.Unwrap(err)
+	}
+	return err.Error()
+}
+
+type summaryReport struct {
+	Timestamp   time.Time           `json:"timestamp"`
+	RunID       string              `json:"runId,omitempty"`
+	Cluster     string              `json:"cluster,omitempty"`
+	Server      string              `json:"server,omitempty"`
+	Options     map[string]string   `json:"options,omitempty"`
+	Matched     int                 `json:"matched"`
+	Targeted    int                 `json:"targeted"`
+	Restarted   []restartedResource `json:"restarted"`
+	Skipped     []skippedPod        `json:"skipped,omitempty"`
+	SkipReasons map[string]int      `json:"skipReasons,omitempty"`
+	Errors      []summaryError      `json:"errors,omitempty"`
+}
+
+func (c *kubeClient) newSummaryReport(result Result) summaryReport {
+	report := summaryReport{
+		Timestamp:   time.Now().UTC(),
+		RunID:       c.opts.RunID,
+		Cluster:     c.cluster,
+		Server:      c.server,
+		Matched:     result.Matched,
+		Targeted:    result.Targeted,
+		Restarted:   result.Restarted,
+		Skipped:     result.Skipped,
+		SkipReasons: result.skipReasons(),
+	}
+	for _, e := range result.Errors {
+		report.Errors = append(report.Errors, newSummaryError(e))
+	}
+	return report
+}
+
+func newSummaryError(e podError) summaryError {
+	summaryErr := summaryError{Pod: e.name, Namespace: e.namespace, Error: e.restartError.Error(), Status: errorStatus(e.restartError)}
+	summaryErr.Webhook, _, _ = webhookDenial(e.restartError)
+	return summaryErr
+}
+
+// invocationOptions captures the value of every flag the tool was run with
+func invocationOptions() map[string]string {
+	options := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		options[f.Name] = f.Value.String()
+	})
+	return options
+}
+
+// writeReportFile persists the JSON report for audit trails, creating parent directories as needed
+// and compressing it for a .gz path
+func writeReportFile(path string, report summaryReport) error {
+	report.Options = invocationOptions()
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, gz, err := openReport(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var w io.Writer = file
+	if gz != nil {
+		w = gz
+	}
+	if _, err := w.Write(append(out, '\n')); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+func (c *kubeClient) printSummary(result Result) {
+	report := c.newSummaryReport(result)
+	if c.report != nil {
+		// the individual outcomes were streamed as they happened, close the pass with the totals
+		totals := report
+		totals.Options = invocationOptions()
+		totals.Restarted, totals.Skipped, totals.Errors = nil, nil, nil
+		if err := c.report.write("summary", c.cluster, totals); err != nil {
+			c.warnf("failed writing report record: %s", err)
+		}
+	} else if c.opts.ReportFile != "" {
+		if err := writeReportFile(c.opts.ReportFile, report); err != nil {
+			c.warnf("failed writing report file: %s: %s", c.opts.ReportFile, err)
+		}
+	}
+
+	if c.opts.Output == "json" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if c.cluster != "" {
+		fmt.Fprintf(w, "cluster\t%s\n", c.cluster)
+	}
+	if c.server != "" {
+		fmt.Fprintf(w, "server\t%s\n", c.server)
+	}
+	if c.opts.RunID != "" {
+		fmt.Fprintf(w, "run id\t%s\n", c.opts.RunID)
+	}
+	fmt.Fprintf(w, "matched pods\t%d\n", result.Matched)
+	fmt.Fprintf(w, "resources targeted\t%d\n", result.Targeted)
+	fmt.Fprintf(w, "restarted\t%d\n", len(result.Restarted))
+	if c.opts.Output != "wide" {
+		for _, r := range result.Restarted {
+			fmt.Fprintf(w, "  %s\timages: %s\n", r.key(), strings.Join(r.Images, ", "))
+			for _, pull := range r.ImagePulls {
+				fmt.Fprintf(w, "    container %s\t%s\n", pull.Container, pull.describe())
+			}
+		}
+	}
+
+	reasons := result.skipReasons()
+	names := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		names = append(names, reason)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "skipped\t%d\n", len(result.Skipped))
+	for _, reason := range names {
+		fmt.Fprintf(w, "  %s\t%d\n", reason, reasons[reason])
+	}
+	if c.opts.ReportSkipped {
+		// name the filter that excluded every matched pod, not just how often each one applied
+		fmt.Fprintf(w, "matched but not restarted\t%d\n", len(result.Skipped))
+		for _, skipped := range result.Skipped {
+			fmt.Fprintf(w, "  %s/%s\t%s\n", skipped.Namespace, skipped.Pod, skipped.Reason)
+		}
+	}
+
+	fmt.Fprintf(w, "failed\t%d\n", len(result.Errors))
+	if c.opts.Verbose {
+		for _, e := range result.Errors {
+			// policy rejections need a policy change, not a retry, so call them out
+			if webhook, message, ok := webhookDenial(e.restartError); ok {
+				fmt.Fprintf(w, "  pod %s\tpolicy rejection by webhook %s: %s\n", e.name, webhook, message)
+				continue
+			}
+			fmt.Fprintf(w, "  pod %s\t%s\n", e.name, e.restartError)
+		}
+	} else {
+		for _, group := range groupErrors(result.Errors) {
+			sort.Strings(group.namespaces)
+			fmt.Fprintf(w, "  %s\t%d occurrences (namespaces: %s)\n", group.cause, group.count, strings.Join(group.namespaces, ", "))
+		}
+	}
+	w.Flush()
+
+	if c.opts.Output == "wide" {
+		fmt.Println()
+		printWideTable(result)
+	}
+}
+
+// printWideTable renders every restarted, skipped and failed resource as a kubectl style table
+func printWideTable(result Result) {
+	orNone := func(value string) string {
+		if value == "" {
+			return "<none>"
+		}
+		return value
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tKIND\tNAME\tTRIGGERED-BY-POD\tNODE\tAGE\tRESULT")
+	for _, r := range result.Restarted {
+		age := "<unknown>"
+		if !r.Created.IsZero() {
+			age = duration.HumanDuration(time.Since(r.Created))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\trestarted\n", r.Namespace, r.Kind, r.Name, orNone(r.Pod), orNone(r.Node), age)
+	}
+	// skips and failures are recorded against the pod, or the workload, that triggered them
+	for _, s := range result.Skipped {
+		fmt.Fprintf(w, "%s\t<none>\t<none>\t%s\t<none>\t<unknown>\tskipped: %s\n", s.Namespace, s.Pod, s.Reason)
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintf(w, "%s\t<none>\t<none>\t%s\t<none>\t<unknown>\tfailed: %s\n", orNone(e.namespace), e.name, errorCause(e.restartError))
+	}
+	w.Flush()
+}
+
+// listedPod describes a matched pod in -list mode
+type listedPod struct {
+	Namespace      string `json:"namespace"`
+	Name           string `json:"name"`
+	Node           string `json:"node,omitempty"`
+	Restarts       int32  `json:"restarts"`
+	LastTerminated string `json:"lastTerminated,omitempty"`
+}
+
+func newListedPod(pod v1.Pod) listedPod {
+	listed := listedPod{Namespace: pod.Namespace, Name: pod.Name, Node: pod.Spec.NodeName}
+
+	// report the reason of the most recent container termination
+	var lastFinished time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		listed.Restarts += status.RestartCount
+		if terminated := status.LastTerminationState.Terminated; terminated != nil && !terminated.FinishedAt.Time.Before(lastFinished) {
+			lastFinished = terminated.FinishedAt.Time
+			listed.LastTerminated = terminated.Reason
+		}
+	}
+	return listed
+}
+
+func (c *kubeClient) printPodList(pods []v1.Pod) {
+	listed := make([]listedPod, 0, len(pods))
+	for _, pod := range pods {
+		listed = append(listed, newListedPod(pod))
+	}
+
+	if c.opts.Output == "json" {
+		out, err := json.MarshalIndent(listed, "", "  ")
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tNODE\tRESTARTS\tLAST TERMINATED")
+	for _, pod := range listed {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", pod.Namespace, pod.Name, pod.Node, pod.Restarts, pod.LastTerminated)
+	}
+	w.Flush()
+
+	// the blast radius at a glance before confirming a cluster wide run
+	if len(pods) > 0 {
+		fmt.Printf("\nmatches per namespace: %s\n", namespaceHistogram(pods))
+	}
+}
+
+// namespaceHistogram counts pods per namespace, most matches first, as prod: 4, staging: 2
+func namespaceHistogram(pods []v1.Pod) string {
+	counts := make(map[string]int)
+	for _, pod := range pods {
+		counts[pod.Namespace]++
+	}
+	namespaces := make([]string, 0, len(counts))
+	for ns := range counts {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if counts[namespaces[i]] != counts[namespaces[j]] {
+			return counts[namespaces[i]] > counts[namespaces[j]]
+		}
+		return namespaces[i] < namespaces[j]
+	})
+
+	entries := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		entries = append(entries, fmt.Sprintf("%s: %d", ns, counts[ns]))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// explainPods prints the decision made for every pod of the pass, from the skips recorded in the
+// summary and the work items that would be restarted
+func (c *kubeClient) explainPods(pods []v1.Pod, sum *summary, items []workItem) {
+	reasons := make(map[string]string)
+	for _, skipped := range sum.skipped {
+		reasons[skipped.Namespace+"/"+skipped.Pod] = skipped.Reason
+	}
+	actions := make(map[string]string)
+	for _, item := range items {
+		for _, name := range item.pods {
+			actions[item.namespace+"/"+name] = fmt.Sprintf("would restart %s/%s", item.kind, item.name)
+		}
+	}
+
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + pod.Name
+		if ok, why := c.matchesPod(pod); !ok {
+			fmt.Printf("pod %s: not matched: %s\n", key, why)
+			continue
+		}
+
+		owner := "none"
+		if len(pod.OwnerReferences) > 0 {
+			owner = fmt.Sprintf("%s/%s", pod.OwnerReferences[0].Kind, pod.OwnerReferences[0].Name)
+		}
+		switch {
+		case actions[key] != "":
+			fmt.Printf("pod %s: matched, owner %s: %s\n", key, owner, actions[key])
+		case reasons[key] != "":
+			fmt.Printf("pod %s: matched, owner %s: skipped: %s\n", key, owner, reasons[key])
+		default:
+			fmt.Printf("pod %s: matched, owner %s: no action\n", key, owner)
+		}
+	}
+}
+
+// webhookDenial extracts the webhook name and its message from an error returned when an
+// admission webhook rejected a request
+func webhookDenial(err error) (string, string, bool) {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return "", "", false
+	}
+	matches := webhookDeniedPattern.FindStringSubmatch(status.Status().Message)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
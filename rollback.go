@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strconv"
+)
+
+// RevisionAnnotation holds the rollout revision of a Deployment and its ReplicaSets
+const RevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// errNoPreviousRevision is returned by rollbackResource when there is nothing to roll back to
+var errNoPreviousRevision = errors.New("no previous revision")
+
+// rollbackResource rolls the resource back to its previous revision, the equivalent of
+// kubectl rollout undo
+func (c *kubeClient) rollbackResource(ctx context.Context, resourceType, name, namespace string) ([]string, error) {
+	switch resourceType {
+	case "ReplicaSet":
+		rs, err := c.clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		for _, ownerRef := range rs.OwnerReferences {
+			if getOwnerResourceType(ownerRef) == "Deployment" {
+				return c.rollbackDeployment(ctx, ownerRef.Name, namespace)
+			}
+		}
+		return nil, fmt.Errorf("%w: replicaset %s has no owning deployment", errNoPreviousRevision, name)
+	case "Deployment":
+		return c.rollbackDeployment(ctx, name, namespace)
+	case "StatefulSet":
+		sts, err := c.clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		if err := c.restartGuard(sts.ObjectMeta); err != nil {
+			return nil, err
+		}
+		revision, err := c.previousControllerRevision(ctx, namespace, sts.UID, sts.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.backupObject(sts); err != nil {
+			return nil, err
+		}
+		sts, err = c.clientSet.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, revision.Data.Raw, metav1.PatchOptions{FieldManager: c.opts.FieldManager})
+		if err != nil {
+			return nil, err
+		}
+		if err := c.stampController(ctx, resourceType, name, namespace); err != nil {
+			return nil, err
+		}
+		return containerImages(sts.Spec.Template.Spec), nil
+	case "DaemonSet":
+		ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, ownerGone(err, resourceType, name)
+		}
+		if err := c.restartGuard(ds.ObjectMeta); err != nil {
+			return nil, err
+		}
+		revision, err := c.previousControllerRevision(ctx, namespace, ds.UID, ds.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.backupObject(ds); err != nil {
+			return nil, err
+		}
+		ds, err = c.clientSet.AppsV1().DaemonSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, revision.Data.Raw, metav1.PatchOptions{FieldManager: c.opts.FieldManager})
+		if err != nil {
+			return nil, err
+		}
+		if err := c.stampController(ctx, resourceType, name, namespace); err != nil {
+			return nil, err
+		}
+		return containerImages(ds.Spec.Template.Spec), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s has no rollout history", errNoPreviousRevision, resourceType)
+}
+
+// rollbackDeployment restores the pod template of the ReplicaSet with the highest revision below
+// the Deployment's current one
+func (c *kubeClient) rollbackDeployment(ctx context.Context, name, namespace string) ([]string, error) {
+	deploy, err := c.clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, ownerGone(err, "Deployment", name)
+	}
+	if err := c.restartGuard(deploy.ObjectMeta); err != nil {
+		return nil, err
+	}
+	current, _ := strconv.ParseInt(deploy.Annotations[RevisionAnnotation], 10, 64)
+
+	selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := c.clientSet.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *appsv1.ReplicaSet
+	var previousRevision int64
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if owner := metav1.GetControllerOf(rs); owner == nil || owner.UID != deploy.UID {
+			continue
+		}
+		revision, err := strconv.ParseInt(rs.Annotations[RevisionAnnotation], 10, 64)
+		if err != nil || revision >= current {
+			continue
+		}
+		if revision > previousRevision {
+			previous, previousRevision = rs, revision
+		}
+	}
+	if previous == nil {
+		return nil, fmt.Errorf("%w: deployment %s is at revision %d", errNoPreviousRevision, name, current)
+	}
+
+	if err := c.backupObject(deploy); err != nil {
+		return nil, err
+	}
+	template := *previous.Spec.Template.DeepCopy()
+	// the hash label is added by the deployment controller and must not be part of the template
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	deploy.Spec.Template = template
+	c.stampRestart(&deploy.ObjectMeta)
+
+	c.infof("rolling back deployment: %s in namespace: %s to revision %d", name, namespace, previousRevision)
+	_, err = c.clientSet.AppsV1().Deployments(namespace).Update(ctx, deploy, metav1.UpdateOptions{FieldManager: c.opts.FieldManager})
+	return containerImages(template.Spec), err
+}
+
+// previousControllerRevision finds the revision before the latest one among the ControllerRevisions
+// owned by a StatefulSet or DaemonSet
+func (c *kubeClient) previousControllerRevision(ctx context.Context, namespace string, owner types.UID, labelSelector *metav1.LabelSelector) (*appsv1.ControllerRevision, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := c.clientSet.AppsV1().ControllerRevisions(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var latest, previous *appsv1.ControllerRevision
+	for i := range revisions.Items {
+		revision := &revisions.Items[i]
+		if controller := metav1.GetControllerOf(revision); controller == nil || controller.UID != owner {
+			continue
+		}
+		switch {
+		case latest == nil || revision.Revision > latest.Revision:
+			previous, latest = latest, revision
+		case previous == nil || revision.Revision > previous.Revision:
+			previous = revision
+		}
+	}
+	if previous == nil {
+		return nil, errNoPreviousRevision
+	}
+
+	c.infof("rolling back to controller revision: %s (revision %d)", previous.Name, previous.Revision)
+	return previous, nil
+}
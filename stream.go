@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// reportRecord is a line of the -report-format jsonl report
+type reportRecord struct {
+	Type    string `json:"type"`
+	Cluster string `json:"cluster,omitempty"`
+	Record  any    `json:"record"`
+}
+
+// reportStream writes report records as newline delimited JSON as the run progresses, so large
+// reports are never held in memory and can be consumed while the run is still going
+type reportStream struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+// openReport opens a report file for writing, gzip compressed for a .gz path and stdout for -
+func openReport(path string) (io.WriteCloser, *gzip.Writer, error) {
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if path != "-" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, nil, err
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = file
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return out, nil, nil
+	}
+	return out, gzip.NewWriter(out), nil
+}
+
+func openReportStream(path string) (*reportStream, error) {
+	out, gz, err := openReport(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &reportStream{out: out, gz: gz, enc: json.NewEncoder(out)}
+	if gz != nil {
+		s.enc = json.NewEncoder(gz)
+	}
+	return s, nil
+}
+
+// write appends a record, a nil stream discards it
+func (s *reportStream) write(recordType, cluster string, record any) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enc.Encode(reportRecord{Type: recordType, Cluster: cluster, Record: record}); err != nil {
+		return err
+	}
+	// flush every record so a reader of a compressed report sees it right away
+	if s.gz != nil {
+		return s.gz.Flush()
+	}
+	return nil
+}
+
+func (s *reportStream) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gz != nil {
+		if err := s.gz.Close(); err != nil {
+			return err
+		}
+	}
+	return s.out.Close()
+}
+
+// nopCloser keeps stdout open when a report written to it is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
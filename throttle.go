@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"strings"
+	"time"
+)
+
+// pressureConditions are the node conditions that count a node as under pressure
+var pressureConditions = []v1.NodeConditionType{v1.NodeMemoryPressure, v1.NodeDiskPressure, v1.NodePIDPressure}
+
+// throttled reports whether the adaptive throttle is enabled
+func (c *kubeClient) throttled() bool {
+	return c.opts.ThrottlePressuredNodes > 0 || c.opts.ThrottlePendingPods > 0
+}
+
+// waitForCapacity pauses before a restart for as long as the cluster is under pressure, checking
+// again every -throttle-interval until it has recovered or the context is cancelled
+func (c *kubeClient) waitForCapacity(ctx context.Context) {
+	if !c.throttled() {
+		return
+	}
+	for {
+		pressure, err := c.clusterPressure(ctx)
+		if err != nil {
+			// a failing check shouldn't block restarts forever, the API server may be the one struggling
+			c.warnf("failed checking cluster pressure, not throttling: %s", err)
+			return
+		}
+		if pressure == "" {
+			return
+		}
+
+		c.infof("cluster under pressure: %s, pausing restarts for %s", pressure, c.opts.ThrottleInterval)
+		timer := time.NewTimer(c.opts.ThrottleInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// clusterPressure describes why the cluster is over the configured thresholds, or returns an
+// empty string when it isn't
+func (c *kubeClient) clusterPressure(ctx context.Context) (string, error) {
+	var reasons []string
+
+	if c.opts.ThrottlePressuredNodes > 0 {
+		nodes, err := c.clientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return "", err
+		}
+		var pressured []string
+		for _, node := range nodes.Items {
+			if nodeUnderPressure(node) {
+				pressured = append(pressured, node.Name)
+			}
+		}
+		if len(pressured) >= c.opts.ThrottlePressuredNodes {
+			reasons = append(reasons, fmt.Sprintf("%d nodes under pressure (%s)", len(pressured), strings.Join(pressured, ", ")))
+		}
+	}
+
+	if c.opts.ThrottlePendingPods > 0 {
+		// only as many pods as the threshold are needed to know it's reached
+		pending, err := c.clientSet.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("status.phase", string(v1.PodPending)).String(),
+			Limit:         int64(c.opts.ThrottlePendingPods),
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(pending.Items) >= c.opts.ThrottlePendingPods {
+			reasons = append(reasons, fmt.Sprintf("at least %d pending pods", len(pending.Items)))
+		}
+	}
+
+	return strings.Join(reasons, ", "), nil
+}
+
+// nodeUnderPressure reports whether the node has any of the pressure conditions set
+func nodeUnderPressure(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		for _, pressure := range pressureConditions {
+			if condition.Type == pressure && condition.Status == v1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// verifyRestarts re-reads every restarted resource and records a failure for each restart that
+// won't take effect, such as a restartedAt annotation on a paused Deployment
+func (c *kubeClient) verifyRestarts(ctx context.Context, sum *summary) {
+	for _, r := range sum.result().Restarted {
+		if err := c.verifyRestart(ctx, r); err != nil {
+			c.warnf("verification failed for %s: %s", r.key(), err)
+			sum.fail(fmt.Sprintf("%s/%s", r.Kind, r.Name), r.Namespace, fmt.Errorf("verify: %w", err))
+		}
+	}
+}
+
+func (c *kubeClient) verifyRestart(ctx context.Context, r restartedResource) error {
+	switch getResourceType(r.Kind) {
+	case "ReplicaSet":
+		rs, err := c.clientSet.AppsV1().ReplicaSets(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		owner := metav1.GetControllerOf(rs)
+		if owner == nil || getOwnerResourceType(*owner) != "Deployment" {
+			return fmt.Errorf("replicaset %s has no owning deployment, nothing was restarted", r.Name)
+		}
+		return c.verifyRestart(ctx, restartedResource{Kind: owner.Kind, Name: owner.Name, Namespace: r.Namespace})
+	case "Deployment":
+		deploy, err := c.clientSet.AppsV1().Deployments(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if deploy.Spec.Paused {
+			return fmt.Errorf("deployment %s is paused, the restart won't roll out until it is resumed", r.Name)
+		}
+		return c.verifyRestartedAt(deploy.Spec.Template.Annotations)
+	case "StatefulSet":
+		sts, err := c.clientSet.AppsV1().StatefulSets(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+			return fmt.Errorf("statefulset %s uses the OnDelete update strategy, pods won't be replaced until deleted", r.Name)
+		}
+		return c.verifyRestartedAt(sts.Spec.Template.Annotations)
+	case "DaemonSet":
+		ds, err := c.clientSet.AppsV1().DaemonSets(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if ds.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+			return fmt.Errorf("daemonset %s uses the OnDelete update strategy, pods won't be replaced until deleted", r.Name)
+		}
+		return c.verifyRestartedAt(ds.Spec.Template.Annotations)
+	case "Pod":
+		// the replaced standalone pod should be gone or on its way out
+		pod, err := c.clientSet.CoreV1().Pods(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if pod.DeletionTimestamp == nil && c.opts.TimeoutAction != TimeoutActionKeep {
+			return fmt.Errorf("replaced pod %s still exists", r.Name)
+		}
+	}
+	return nil
+}
+
+func (c *kubeClient) verifyRestartedAt(annotations map[string]string) error {
+	// a rollback restores a template that may predate any restart, and evicting pods leaves the
+	// template alone
+	if c.opts.Rollback || c.opts.EvictPods {
+		return nil
+	}
+	// an annotation left behind by an earlier restart doesn't show this one was persisted
+	if stamped, want := annotations["kubectl.kubernetes.io/restartedAt"], c.restartTimestamp(); stamped != want {
+		return fmt.Errorf("restartedAt annotation is %q instead of %q stamped by this run, the update wasn't persisted", stamped, want)
+	}
+	return nil
+}
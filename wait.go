@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"sort"
+	"sync"
+	"time"
+)
+
+// waitForRollout blocks until the restarted controller has rolled out its updated template. The
+// whole wait shares one -wait-timeout deadline, however many polls a rollout takes.
+func (c *kubeClient) waitForRollout(ctx context.Context, resourceType, name, namespace string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.WaitTimeout)
+	defer cancel()
+
+	switch resourceType {
+	case "ReplicaSet":
+		// ReplicaSets are restarted through their owning Deployment, so wait on that instead
+		rs, err := c.clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, ownerRef := range rs.OwnerReferences {
+			if getOwnerResourceType(ownerRef) == "Deployment" {
+				return c.waitForDeployment(ctx, ownerRef.Name, namespace)
+			}
+		}
+	case "Deployment":
+		return c.waitForDeployment(ctx, name, namespace)
+	case "StatefulSet":
+		return c.waitForStatefulSet(ctx, name, namespace)
+	case "DaemonSet":
+		return c.waitForDaemonSet(ctx, name, namespace)
+	}
+
+	return nil
+}
+
+// waitForAll waits for the restarted controllers to roll out concurrently under one shared
+// -wait-timeout deadline, records a failure for every one that didn't and returns the readiness of
+// each by resource key
+func (c *kubeClient) waitForAll(ctx context.Context, sum *summary, restarted []restartedResource) map[string]bool {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.WaitTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ready := make(map[string]bool)
+	for _, r := range restarted {
+		// standalone pods are already running by the time restartPod returns
+		if r.Kind == "Pod" {
+			continue
+		}
+		wg.Add(1)
+		go func(r restartedResource) {
+			defer wg.Done()
+			err := c.waitForRollout(ctx, getResourceType(r.Kind), r.Name, r.Namespace)
+			mu.Lock()
+			ready[r.key()] = err == nil
+			mu.Unlock()
+			if err != nil {
+				sum.fail(fmt.Sprintf("%s/%s", r.Kind, r.Name), r.Namespace, fmt.Errorf("not rolled out before the shared deadline: %w", err))
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	keys := make([]string, 0, len(ready))
+	for key := range ready {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if ready[key] {
+			c.infof("rolled out: %s", key)
+		} else {
+			c.warnf("not rolled out before the deadline: %s", key)
+		}
+	}
+	return ready
+}
+
+// watchFunc opens a watch on the object a rollout wait is following
+type watchFunc func(ctx context.Context) (watch.Interface, error)
+
+// byName selects a single object by name in a watch
+func byName(name string) metav1.ListOptions {
+	return metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()}
+}
+
+// pollRollout re-checks the condition on every change of the watched object, and falls back to
+// polling when the watch can't be established or stays silent for -watch-timeout. It runs until
+// the deadline of the context waitForRollout set for the whole rollout.
+func (c *kubeClient) pollRollout(ctx context.Context, open watchFunc, condition wait.ConditionWithContextFunc) error {
+	if c.opts.WatchTimeout > 0 {
+		done, err := c.watchRollout(ctx, open, condition)
+		if done || err != nil {
+			return err
+		}
+	}
+	return wait.PollUntilContextCancel(ctx, ConfigRestartInterval*time.Second, true, condition)
+}
+
+// watchRollout evaluates the condition on every watch event. It returns false without an error
+// when the caller should fall back to polling
+func (c *kubeClient) watchRollout(ctx context.Context, open watchFunc, condition wait.ConditionWithContextFunc) (bool, error) {
+	if done, err := condition(ctx); done || err != nil {
+		return done, err
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	establish := time.AfterFunc(c.opts.WatchTimeout, cancel)
+	watcher, err := open(watchCtx)
+	if !establish.Stop() || err != nil {
+		c.debugf("watch not established within %s, falling back to polling: %v", c.opts.WatchTimeout, err)
+		return false, nil
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(c.opts.WatchTimeout):
+			c.debugf("no watch events within %s, falling back to polling", c.opts.WatchTimeout)
+			return false, nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type == watch.Error {
+				c.debugf("watch closed, falling back to polling")
+				return false, nil
+			}
+			if done, err := condition(ctx); done || err != nil {
+				return done, err
+			}
+		}
+	}
+}
+
+func (c *kubeClient) waitForDeployment(ctx context.Context, name, namespace string) error {
+	c.infof("waiting for deployment: %s in namespace: %s to roll out", name, namespace)
+	watchDeployment := func(ctx context.Context) (watch.Interface, error) {
+		return c.clientSet.AppsV1().Deployments(namespace).Watch(ctx, byName(name))
+	}
+	err := c.pollRollout(ctx, watchDeployment, func(ctx context.Context) (bool, error) {
+		deploy, err := c.clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if deploy.Spec.Replicas != nil {
+			replicas = *deploy.Spec.Replicas
+		}
+		return deploy.Status.ObservedGeneration >= deploy.Generation &&
+			deploy.Status.UpdatedReplicas == replicas &&
+			deploy.Status.Replicas == replicas &&
+			deploy.Status.AvailableReplicas == replicas, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for deployment %s/%s to roll out: %w", namespace, name, err)
+	}
+	return nil
+}
+
+func (c *kubeClient) waitForDaemonSet(ctx context.Context, name, namespace string) error {
+	c.infof("waiting for daemonset: %s in namespace: %s to roll out", name, namespace)
+	watchDaemonSet := func(ctx context.Context) (watch.Interface, error) {
+		return c.clientSet.AppsV1().DaemonSets(namespace).Watch(ctx, byName(name))
+	}
+	err := c.pollRollout(ctx, watchDaemonSet, func(ctx context.Context) (bool, error) {
+		ds, err := c.clientSet.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return ds.Status.ObservedGeneration >= ds.Generation &&
+			ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled &&
+			ds.Status.NumberAvailable == ds.Status.DesiredNumberScheduled, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for daemonset %s/%s to roll out: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// waitForStatefulSet follows the controller through the rollout. With OrderedReady pod management
+// the controller replaces pods one ordinal at a time from the highest down, so each ordinal has to
+// be running the update revision and Ready before the next one is considered.
+func (c *kubeClient) waitForStatefulSet(ctx context.Context, name, namespace string) error {
+	var sts *appsv1.StatefulSet
+	getStatefulSet := func(ctx context.Context) (bool, error) {
+		var err error
+		sts, err = c.clientSet.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		// updateRevision is only meaningful once the controller has seen the new template
+		return sts.Status.ObservedGeneration >= sts.Generation, nil
+	}
+
+	watchStatefulSet := func(ctx context.Context) (watch.Interface, error) {
+		return c.clientSet.AppsV1().StatefulSets(namespace).Watch(ctx, byName(name))
+	}
+
+	c.infof("waiting for statefulset: %s in namespace: %s to roll out", name, namespace)
+	if err := c.pollRollout(ctx, watchStatefulSet, getStatefulSet); err != nil {
+		return fmt.Errorf("waiting for statefulset %s/%s to observe restart: %w", namespace, name, err)
+	}
+
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+
+	// ordinals below a rolling update partition are intentionally left on the current revision
+	partition := int32(0)
+	if rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		partition = *rollingUpdate.Partition
+	}
+
+	if sts.Spec.PodManagementPolicy != appsv1.ParallelPodManagement {
+		for ordinal := replicas - 1; ordinal >= partition; ordinal-- {
+			podName := fmt.Sprintf("%s-%d", name, ordinal)
+			watchPod := func(ctx context.Context) (watch.Interface, error) {
+				return c.clientSet.CoreV1().Pods(namespace).Watch(ctx, byName(podName))
+			}
+			err := c.pollRollout(ctx, watchPod, func(ctx context.Context) (bool, error) {
+				if _, err := getStatefulSet(ctx); err != nil {
+					return false, err
+				}
+				pod, err := c.clientSet.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					// the controller deletes the old pod before creating its replacement
+					return false, nil
+				}
+				if err != nil {
+					return false, err
+				}
+				return pod.Labels[appsv1.ControllerRevisionHashLabelKey] == sts.Status.UpdateRevision && isPodReady(pod), nil
+			})
+			if err != nil {
+				return fmt.Errorf("waiting for statefulset %s/%s ordinal %d: %w", namespace, name, ordinal, err)
+			}
+			c.infof("statefulset: %s ordinal %d updated and ready in namespace: %s (%d/%d)", name, ordinal, namespace, replicas-ordinal, replicas-partition)
+		}
+	}
+
+	err := c.pollRollout(ctx, watchStatefulSet, func(ctx context.Context) (bool, error) {
+		if observed, err := getStatefulSet(ctx); !observed || err != nil {
+			return false, err
+		}
+		if partition > 0 {
+			return sts.Status.UpdatedReplicas >= replicas-partition, nil
+		}
+		return sts.Status.UpdatedReplicas == replicas && sts.Status.CurrentRevision == sts.Status.UpdateRevision, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for statefulset %s/%s to roll out: %w", namespace, name, err)
+	}
+	return nil
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"strings"
+)
+
+// restartWorkloads performs a single restart pass over Deployments, StatefulSets and DaemonSets
+// found directly through their typed clients rather than through their pods
+func (c *kubeClient) restartWorkloads(ctx context.Context) (Result, error) {
+	sum := c.newSummary()
+	if err := c.preparePass(ctx); err != nil {
+		sum.fail(c.opts.ConfigMapName, c.opts.ConfigMapNamespace, err)
+		return c.finishPass(sum), nil
+	}
+
+	items, err := c.listWorkloads(ctx, sum)
+	if err != nil {
+		return Result{}, err
+	}
+	// excluded workloads were matched too, they are recorded as skipped
+	sum.matched = len(items) + len(sum.skipped)
+
+	items = c.sampleWorkItems(sum, items)
+	if c.opts.Diff {
+		c.diffWorkItems(ctx, items)
+		return c.previewPass(sum, items), nil
+	}
+	c.restartStages(ctx, sum, items)
+	if c.opts.Verify {
+		c.verifyRestarts(ctx, sum)
+	}
+	return c.finishPass(sum), nil
+}
+
+// restartTarget restarts the single object named by -target-kind, -target-name and
+// -target-namespace without listing pods or workloads
+func (c *kubeClient) restartTarget(ctx context.Context) (Result, error) {
+	kind, name, namespace := c.opts.TargetKind, c.opts.TargetName, c.opts.TargetNamespace
+	item := workItem{
+		resourceType: getResourceType(kind),
+		kind:         kind,
+		name:         name,
+		namespace:    namespace,
+		pod:          v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}},
+	}
+	if kind == "Pod" {
+		// standalone pods are recreated from their own spec
+		pod, err := c.clientSet.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return Result{}, err
+		}
+		item.resourceType, item.pod, item.pods = "Pod", *pod, []string{pod.Name}
+	}
+
+	sum := c.newSummary()
+	if err := c.preparePass(ctx); err != nil {
+		sum.fail(c.opts.ConfigMapName, c.opts.ConfigMapNamespace, err)
+		return c.finishPass(sum), nil
+	}
+	sum.matched = 1
+
+	if c.opts.Diff {
+		c.diffWorkItems(ctx, []workItem{item})
+		return c.previewPass(sum, []workItem{item}), nil
+	}
+	c.restartStages(ctx, sum, []workItem{item})
+	if c.opts.Verify {
+		c.verifyRestarts(ctx, sum)
+	}
+	return c.finishPass(sum), nil
+}
+
+// listWorkloads finds the workloads to restart in every target namespace. Without a selector or
+// -workload-annotation workloads are matched on -match in the name, the same as pods.
+func (c *kubeClient) listWorkloads(ctx context.Context, sum *summary) ([]workItem, error) {
+	namespaces := []string{metav1.NamespaceAll}
+	if c.scopedToNamespaces() {
+		var err error
+		namespaces, err = c.targetNamespaces(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var items []workItem
+	add := func(kind string, meta metav1.ObjectMeta, spec v1.PodSpec) {
+		if c.opts.WorkloadAnnotationKey != "" {
+			if value, ok := meta.Annotations[c.opts.WorkloadAnnotationKey]; !ok || value != c.opts.WorkloadAnnotationValue {
+				return
+			}
+		} else if !c.usesSelectors() && !strings.Contains(meta.Name, c.opts.Match) {
+			return
+		}
+		if c.opts.ContainerName != "" && !hasContainer(spec, c.opts.ContainerName, c.opts.IncludeInitContainers) {
+			return
+		}
+		item := workItem{
+			resourceType: kind,
+			kind:         kind,
+			name:         meta.Name,
+			namespace:    meta.Namespace,
+			// there is no triggering pod, restartResource only needs the namespace
+			pod: v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: meta.Namespace}},
+		}
+		if slices.Contains(c.opts.ExcludeKinds, kind) {
+			c.infof("skipping %s of excluded kind: %s in namespace: %s", kind, meta.Name, meta.Namespace)
+			sum.skipItem(item, SkipReasonExcludedKind)
+			return
+		}
+		if slices.Contains(c.opts.ExcludeOwnerNames, meta.Name) {
+			c.infof("skipping excluded %s: %s in namespace: %s", kind, meta.Name, meta.Namespace)
+			sum.skipItem(item, SkipReasonExcludedOwner)
+			return
+		}
+		items = append(items, item)
+	}
+
+	opts := c.podListOptions()
+	for _, ns := range namespaces {
+		deployments, err := c.clientSet.AppsV1().Deployments(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing deployments: %w", err)
+		}
+		for _, deploy := range deployments.Items {
+			add("Deployment", deploy.ObjectMeta, deploy.Spec.Template.Spec)
+		}
+
+		statefulSets, err := c.clientSet.AppsV1().StatefulSets(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing statefulsets: %w", err)
+		}
+		for _, sts := range statefulSets.Items {
+			add("StatefulSet", sts.ObjectMeta, sts.Spec.Template.Spec)
+		}
+
+		daemonSets, err := c.clientSet.AppsV1().DaemonSets(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing daemonsets: %w", err)
+		}
+		for _, ds := range daemonSets.Items {
+			add("DaemonSet", ds.ObjectMeta, ds.Spec.Template.Spec)
+		}
+	}
+
+	return items, nil
+}